@@ -1,5 +1,6 @@
 {
   lib,
+  stdenv,
   buildGo126Module,
   pkg-config,
   openconnect_openssl,
@@ -61,7 +62,10 @@ in buildGo126Module (final: {
   };
 
   nativeBuildInputs = [ pkg-config ];
-  buildInputs = [ openconnect' apple-sdk_15 (darwinMinVersionHook "14.4") ];
+  buildInputs = [ openconnect' ] ++ lib.optionals stdenv.hostPlatform.isDarwin [
+    apple-sdk_15
+    (darwinMinVersionHook "14.4")
+  ];
 
   ldflags = [
     "-w"
@@ -0,0 +1,30 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/jessevdk/go-flags"
+
+	"github.com/gilliginsisland/pacman/internal/app"
+)
+
+func init() {
+	c, _ := parser.AddCommand("ca", "Local certificate authority", "Manage the CA issuing the certificates of TLS listeners", &struct{}{})
+	c.SubcommandsOptional = false
+	c.AddCommand("export", "Print the CA certificate", "Print the PEM certificate of the local CA, generating the CA if needed, to be trusted by clients of TLS listeners", &CAExportCmd{})
+}
+
+var _ flags.Commander = (*CAExportCmd)(nil)
+
+// CAExportCmd defines the "ca export" command.
+type CAExportCmd struct{}
+
+// Execute runs the ca export command.
+func (c *CAExportCmd) Execute(args []string) error {
+	ca, err := app.LocalCA()
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(ca.CertPEM)
+	return err
+}
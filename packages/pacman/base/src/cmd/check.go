@@ -1,16 +1,20 @@
 package cmd
 
 import (
+	"fmt"
+	"net"
 	"os"
+	"strconv"
 
 	"github.com/jessevdk/go-flags"
+	"golang.org/x/net/proxy"
 
 	"github.com/gilliginsisland/pacman/internal/app"
-	"github.com/gilliginsisland/pacman/pkg/trie"
+	"github.com/gilliginsisland/pacman/pkg/dialer"
 )
 
 func init() {
-	parser.AddCommand("check", "Check host rules", "Check if a host matches the ruleset", &CheckCmd{})
+	parser.AddCommand("check", "Check host rules", "Check if a host or host:port matches the ruleset", &CheckCmd{})
 }
 
 var _ flags.Commander = (*CheckCmd)(nil)
@@ -25,15 +29,32 @@ func (c *CheckCmd) Execute(args []string) error {
 		return err
 	}
 
-	var t trie.Trie[struct{}]
+	var t dialer.RuleSet
 	for _, rule := range rs.Rules {
 		for _, host := range rule.Hosts {
-			t.Insert(host, struct{}{})
+			t.AddFiltered(host, rule.Filter(), proxy.Direct)
+		}
+		// host lists from URLs are only checked against their cached copy
+		for _, hl := range rule.HostLists {
+			hosts, _ := hl.Cached()
+			for _, host := range hosts {
+				t.AddFiltered(host, rule.Filter(), proxy.Direct)
+			}
 		}
 	}
 
-	for _, host := range args {
-		if _, ok := t.Match(host); ok {
+	// arguments are host or host:port, without a port
+	// rules restricted to ports match any port
+	for _, arg := range args {
+		host, port := arg, 0
+		if h, p, err := net.SplitHostPort(arg); err == nil {
+			n, err := strconv.ParseUint(p, 10, 16)
+			if err != nil {
+				return fmt.Errorf("invalid port %q in %s", p, arg)
+			}
+			host, port = h, int(n)
+		}
+		if _, ok := t.Match("tcp", host, port); ok {
 			return nil
 		}
 	}
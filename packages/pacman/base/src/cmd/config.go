@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/jessevdk/go-flags"
+
+	"github.com/gilliginsisland/pacman/internal/app"
+)
+
+func init() {
+	c, _ := parser.AddCommand("config", "Config file tools", "Inspect and check the config file", &struct{}{})
+	c.SubcommandsOptional = false
+	c.AddCommand("validate", "Validate the config", "Report mistakes in the config file and exit non-zero if any are found", &ValidateCmd{})
+}
+
+var _ flags.Commander = (*ValidateCmd)(nil)
+
+// ValidateCmd defines the "config validate" command.
+type ValidateCmd struct{}
+
+// Execute runs the validate command.
+func (c *ValidateCmd) Execute(args []string) error {
+	cfg, err := app.ParseConfigFile(opts.ConfigPath)
+	if err != nil {
+		return err
+	}
+
+	diags := app.Validate(cfg)
+	for _, d := range diags {
+		fmt.Println(d)
+	}
+	if len(diags) > 0 {
+		return fmt.Errorf("%s: %d problem(s) found", opts.ConfigPath, len(diags))
+	}
+	return nil
+}
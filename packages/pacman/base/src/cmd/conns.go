@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/jessevdk/go-flags"
+)
+
+var _ flags.Commander = (*ConnsCmd)(nil)
+
+// ConnsCmd defines the "conns" command.
+type ConnsCmd struct {
+	CtlOpts
+	Proxy string `short:"p" long:"proxy" description:"Only the connections through this proxy"`
+}
+
+var connsCmd ConnsCmd
+
+func init() {
+	c, _ := parser.AddCommand("conns", "List open connections", "List the connections of the running proxy with their destination, rule, proxy and bytes transferred", &connsCmd)
+	c.SubcommandsOptional = true
+	c.AddCommand("kill", "Close connections", "Close the connection with the given id, or with --proxy all connections through a proxy", &ConnsKillCmd{})
+}
+
+func (c *ConnsCmd) Execute(args []string) error {
+	path := "connections"
+	if c.Proxy != "" {
+		path += "?" + url.Values{"proxy": {c.Proxy}}.Encode()
+	}
+	return ctl(c.Address, http.MethodGet, path)
+}
+
+var _ flags.Commander = (*ConnsKillCmd)(nil)
+
+// ConnsKillCmd defines the "conns kill" command.
+type ConnsKillCmd struct {
+	Args struct {
+		ID string `positional-arg-name:"id"`
+	} `positional-args:"yes"`
+}
+
+func (c *ConnsKillCmd) Execute(args []string) error {
+	switch {
+	case c.Args.ID != "" && connsCmd.Proxy != "":
+		return errors.New("give either a connection id or --proxy, not both")
+	case c.Args.ID != "":
+		if _, err := strconv.ParseUint(c.Args.ID, 10, 64); err != nil {
+			return errors.New("invalid connection id " + strconv.Quote(c.Args.ID))
+		}
+		return ctl(connsCmd.Address, http.MethodDelete, "connections/"+c.Args.ID)
+	case connsCmd.Proxy != "":
+		return ctl(connsCmd.Address, http.MethodDelete, "connections?"+url.Values{"proxy": {connsCmd.Proxy}}.Encode())
+	default:
+		return errors.New("give a connection id or --proxy")
+	}
+}
@@ -0,0 +1,102 @@
+package cmd
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/jessevdk/go-flags"
+
+	"github.com/gilliginsisland/pacman/internal/app"
+)
+
+// CtlOpts are the options shared by the ctl subcommands.
+type CtlOpts struct {
+	Address string `short:"a" long:"address" description:"Address of the control API, host:port or unix:/path (default: from the config)"`
+}
+
+var ctlOpts CtlOpts
+
+func init() {
+	c, _ := parser.AddCommand("ctl", "Control the running proxy", "Query and control a running PACman through its control API", &ctlOpts)
+	c.SubcommandsOptional = false
+	c.AddCommand("proxies", "List proxies", "List the proxies with their connection state", &CtlProxiesCmd{})
+	c.AddCommand("connect", "Connect a proxy", "Connect the proxy with the given label", &CtlActionCmd{action: "connect"})
+	c.AddCommand("disconnect", "Disconnect a proxy", "Disconnect the proxy with the given label", &CtlActionCmd{action: "disconnect"})
+	c.AddCommand("reset", "Reset a failed proxy", "Reset the failed proxy with the given label", &CtlActionCmd{action: "reset"})
+	c.AddCommand("reload", "Reload the config", "Reload the config of the running proxy", &CtlReloadCmd{})
+	c.AddCommand("route", "Show how a host is routed", "Show the rule and proxies a connection to host or host:port would use", &CtlRouteCmd{})
+}
+
+// ctl calls the control API at address, or the one found in the config
+// if empty, and prints the response.
+func ctl(address, method, path string) error {
+	cfg, err := app.ParseConfigFile(opts.ConfigPath)
+	if err != nil {
+		return err
+	}
+	client, base, err := app.ControlClient(cfg, address)
+	if err != nil {
+		return err
+	}
+	body, err := app.CallAPI(context.Background(), client, method, base+path)
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(body)
+	return err
+}
+
+var _ flags.Commander = (*CtlProxiesCmd)(nil)
+
+// CtlProxiesCmd defines the "ctl proxies" command.
+type CtlProxiesCmd struct{}
+
+func (c *CtlProxiesCmd) Execute(args []string) error {
+	return ctl(ctlOpts.Address, http.MethodGet, "proxies")
+}
+
+var _ flags.Commander = (*CtlActionCmd)(nil)
+
+// CtlActionCmd defines the "ctl connect", "ctl disconnect" and "ctl reset" commands.
+type CtlActionCmd struct {
+	action string
+	Args   struct {
+		Label string `positional-arg-name:"label" required:"yes"`
+	} `positional-args:"yes"`
+}
+
+func (c *CtlActionCmd) Execute(args []string) error {
+	return ctl(ctlOpts.Address, http.MethodPost, "proxies/"+url.PathEscape(c.Args.Label)+"/"+c.action)
+}
+
+var _ flags.Commander = (*CtlReloadCmd)(nil)
+
+// CtlReloadCmd defines the "ctl reload" command.
+type CtlReloadCmd struct{}
+
+func (c *CtlReloadCmd) Execute(args []string) error {
+	return ctl(ctlOpts.Address, http.MethodPost, "reload")
+}
+
+var _ flags.Commander = (*CtlRouteCmd)(nil)
+
+// CtlRouteCmd defines the "ctl route" command.
+type CtlRouteCmd struct {
+	Profile string `short:"p" long:"profile" description:"Rule profile of the listener (default: the top level rules)"`
+	Network string `short:"n" long:"network" default:"tcp" choice:"tcp" choice:"udp" description:"Network of the connection"`
+	Args    struct {
+		Host string `positional-arg-name:"host[:port]" required:"yes"`
+	} `positional-args:"yes"`
+}
+
+func (c *CtlRouteCmd) Execute(args []string) error {
+	q := url.Values{}
+	q.Set("host", c.Args.Host)
+	q.Set("network", c.Network)
+	if c.Profile != "" {
+		q.Set("profile", c.Profile)
+	}
+	return ctl(ctlOpts.Address, http.MethodGet, "route?"+q.Encode())
+}
@@ -0,0 +1,138 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/jessevdk/go-flags"
+
+	"github.com/gilliginsisland/pacman/internal/app"
+)
+
+func init() {
+	parser.AddCommand("explain", "Explain how hosts are routed", "Show how connections to hosts or URLs are routed by the rules of the config. Without arguments, they are read from stdin, one per line", &ExplainCmd{})
+}
+
+var _ flags.Commander = (*ExplainCmd)(nil)
+
+// ExplainCmd defines the "explain" command.
+type ExplainCmd struct {
+	Profile string `short:"p" long:"profile" description:"Rule profile of the listener (default: the top level rules)"`
+	Network string `short:"n" long:"network" default:"tcp" choice:"tcp" choice:"udp" description:"Network of the connection"`
+	JSON    bool   `long:"json" description:"Print one JSON object per line"`
+	Args    struct {
+		Targets []string `positional-arg-name:"host[:port]|url"`
+	} `positional-args:"yes"`
+}
+
+// Execute runs the explain command.
+func (c *ExplainCmd) Execute(args []string) error {
+	cfg, err := app.ParseConfigFile(opts.ConfigPath)
+	if err != nil {
+		return err
+	}
+	if _, ok := cfg.Profiles[c.Profile]; c.Profile != "" && !ok {
+		return fmt.Errorf("profile %q is not defined", c.Profile)
+	}
+	e, err := app.NewExplainer(cfg)
+	if err != nil {
+		return err
+	}
+
+	targets := c.Args.Targets
+	if len(targets) == 0 {
+		if targets, err = readTargets(os.Stdin); err != nil {
+			return err
+		}
+	}
+
+	failed := false
+	enc := json.NewEncoder(os.Stdout)
+	for i, target := range targets {
+		ex := e.Explain(context.Background(), c.Profile, c.Network, target)
+		failed = failed || ex.Error != ""
+		if c.JSON {
+			if err := enc.Encode(ex); err != nil {
+				return err
+			}
+			continue
+		}
+		if i > 0 {
+			fmt.Println()
+		}
+		printExplanation(os.Stdout, ex)
+	}
+	if failed {
+		return errors.New("some targets could not be explained")
+	}
+	return nil
+}
+
+// readTargets reads one target per line, skipping blank lines
+// and comments starting with #.
+func readTargets(r io.Reader) ([]string, error) {
+	var targets []string
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		targets = append(targets, line)
+	}
+	return targets, s.Err()
+}
+
+func printExplanation(w io.Writer, ex app.Explanation) {
+	fmt.Fprintln(w, ex.Input)
+	if ex.Error != "" {
+		fmt.Fprintf(w, "  error:    %s\n", ex.Error)
+		return
+	}
+	ri := ex.RouteInfo
+	host := ri.Host
+	if ri.Port != 0 {
+		host += ":" + strconv.Itoa(ri.Port)
+	}
+	fmt.Fprintf(w, "  host:     %s (%s)\n", host, ri.Network)
+	if ri.Resolved != "" {
+		fmt.Fprintf(w, "  hosts:    rewritten to %s by the hosts file\n", ri.Resolved)
+	}
+	if ri.Pattern == "" {
+		fmt.Fprintln(w, "  match:    none")
+	} else {
+		match := fmt.Sprintf("%s (%s)", ri.Pattern, ri.Match)
+		if ri.Filter != "" {
+			match += ", " + ri.Filter
+		}
+		fmt.Fprintf(w, "  match:    %s\n", match)
+	}
+	switch {
+	case ri.Rule != "":
+		fmt.Fprintf(w, "  rule:     %s\n", ri.Rule)
+	case ri.Pattern != "":
+		fmt.Fprintln(w, "  rule:     proxy alias")
+	}
+	if ri.Rewritten != "" {
+		fmt.Fprintf(w, "  dials:    %s\n", ri.Rewritten)
+	}
+	if len(ri.Proxies) > 0 {
+		proxies := strings.Join(ri.Proxies, ", ")
+		if len(ri.Proxies) > 1 {
+			strategy := ri.Strategy
+			if strategy == "" {
+				strategy = app.StrategySequential
+			}
+			proxies += " (" + string(strategy) + ")"
+		}
+		fmt.Fprintf(w, "  proxies:  %s\n", proxies)
+	}
+	fmt.Fprintf(w, "  action:   %s\n", ri.Action)
+}
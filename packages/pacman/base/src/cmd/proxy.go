@@ -8,6 +8,7 @@ import (
 
 	"github.com/gilliginsisland/pacman/internal/app"
 	"github.com/gilliginsisland/pacman/pkg/launch"
+	"github.com/gilliginsisland/pacman/pkg/prompt"
 )
 
 func init() {
@@ -17,7 +18,9 @@ func init() {
 var _ flags.Commander = (*ProxyCommand)(nil)
 
 type ProxyCommand struct {
-	Launchd bool `long:"launchd" description:"Use launchd socket activation"`
+	Launchd  bool   `long:"launchd" description:"Use launchd socket activation"`
+	Headless bool   `long:"headless" description:"Run as a daemon without the menu bar"`
+	Askpass  string `long:"askpass" env:"PACMAN_ASKPASS" description:"Program used for authentication prompts when headless"`
 }
 
 // Execute runs the proxy subcommand
@@ -29,7 +32,12 @@ func (c *ProxyCommand) Execute(args []string) error {
 			return err
 		}
 	}
-	return app.Run(opts.ConfigPath, l)
+	ui := app.NewUI(c.Headless)
+	// the menu bar prompts with notifications
+	if _, headless := ui.(*app.Headless); headless && c.Askpass != "" {
+		prompt.SetDefault(&prompt.Command{Path: c.Askpass})
+	}
+	return app.Run(opts.ConfigPath, l, ui)
 }
 
 func (c *ProxyCommand) listener() (net.Listener, error) {
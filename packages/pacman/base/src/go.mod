@@ -3,6 +3,7 @@ module github.com/gilliginsisland/pacman
 go 1.26.1
 
 require (
+	github.com/godbus/dbus/v5 v5.1.0
 	github.com/google/gopacket v1.1.19
 	github.com/jessevdk/go-flags v1.6.1
 	golang.org/x/crypto v0.49.0
@@ -0,0 +1,98 @@
+package app
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/gilliginsisland/pacman/pkg/logfile"
+)
+
+// AccessLog writes a JSON line for every connection once it ends.
+type AccessLog struct {
+	Path    Path     `json:"path"`
+	MaxSize int64    `json:"max_size"`    // megabytes before rotating, DefaultAccessLogSize if zero
+	MaxAge  Duration `json:"max_age"`     // time before rotating, DefaultAccessLogAge if zero
+	Backups int      `json:"max_backups"` // rotated files kept, DefaultAccessLogBackups if zero
+}
+
+const (
+	DefaultAccessLogSize    = 10 // megabytes
+	DefaultAccessLogAge     = 24 * time.Hour
+	DefaultAccessLogBackups = 5
+)
+
+// open returns the rotating writer of the log.
+func (a *AccessLog) open() (io.WriteCloser, error) {
+	path, err := a.Path.ExpandUser()
+	if err != nil {
+		return nil, err
+	}
+	w := &logfile.Writer{
+		Path:    path,
+		MaxSize: DefaultAccessLogSize << 20,
+		MaxAge:  DefaultAccessLogAge,
+		Backups: DefaultAccessLogBackups,
+	}
+	if a.MaxSize > 0 {
+		w.MaxSize = a.MaxSize << 20
+	}
+	if a.MaxAge > 0 {
+		w.MaxAge = time.Duration(a.MaxAge)
+	}
+	if a.Backups > 0 {
+		w.Backups = a.Backups
+	}
+	return w, nil
+}
+
+// AccessEntry is a line of the access log.
+type AccessEntry struct {
+	Time        time.Time `json:"time"` // when the connection ended
+	Protocol    Protocol  `json:"protocol"`
+	Client      string    `json:"client,omitempty"`
+	Network     string    `json:"network"`
+	Destination string    `json:"destination"`
+	Rule        string    `json:"rule,omitempty"`
+	Chain       []string  `json:"chain,omitempty"`
+	DialMillis  float64   `json:"dial_ms"`
+	Millis      float64   `json:"duration_ms"`
+	Sent        int64     `json:"sent"`
+	Received    int64     `json:"received"`
+	Error       string    `json:"error,omitempty"`
+}
+
+func newAccessEntry(info ConnInfo, dial time.Duration, err error) AccessEntry {
+	now := time.Now()
+	e := AccessEntry{
+		Time:        now,
+		Protocol:    info.Protocol,
+		Client:      info.Client,
+		Network:     info.Network,
+		Destination: info.Destination,
+		Rule:        info.Rule,
+		Chain:       info.Chain,
+		DialMillis:  millis(dial),
+		Millis:      millis(now.Sub(info.Start)),
+		Sent:        info.Sent,
+		Received:    info.Received,
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	return e
+}
+
+// millis returns d in milliseconds, to the microsecond.
+func millis(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// writeAccess writes the entry as a single line.
+func writeAccess(w io.Writer, e AccessEntry) {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	w.Write(append(line, '\n'))
+}
@@ -0,0 +1,505 @@
+package app
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gilliginsisland/pacman/pkg/dialer"
+	"github.com/gilliginsisland/pacman/pkg/iterutil"
+	"github.com/gilliginsisland/pacman/pkg/netutil"
+	"github.com/gilliginsisland/pacman/pkg/trie"
+)
+
+// APIPrefix is the path the control API is served under.
+const APIPrefix = "/api/v1/"
+
+// APIRequestHeader must be sent with requests that change the state of
+// PACman. Browsers only send a custom header cross-site after a CORS
+// preflight, which the API never allows, so forms and scripts of other
+// sites cannot post to the API. It does not stop a page that rebinds its
+// own name to the address of a listener, whose requests are same-origin.
+// TCP listeners refuse those by their Host header, see requireHost.
+const APIRequestHeader = "X-PACman-Request"
+
+// ProxyStatus describes a proxy of the pool.
+type ProxyStatus struct {
+	Label string `json:"label"`
+	URL   string `json:"url"` // password redacted
+	State string `json:"state"`
+	Error string `json:"error,omitempty"` // why the proxy failed or disconnected
+}
+
+// ProxyEvent is a state change of a proxy.
+type ProxyEvent struct {
+	Proxy string    `json:"proxy"`
+	Seq   uint64    `json:"seq"` // number of the change among those of the proxy
+	Time  time.Time `json:"time"`
+	State string    `json:"state"`
+	Error string    `json:"error,omitempty"`
+}
+
+func newProxyEvent(label string, e dialer.Event) ProxyEvent {
+	pe := ProxyEvent{
+		Proxy: label,
+		Seq:   e.Seq,
+		Time:  e.Time,
+		State: e.Value.State.String(),
+	}
+	if e.Value.Err != nil {
+		pe.Error = e.Value.Err.Error()
+	}
+	return pe
+}
+
+// RouteInfo is how a connection would be routed.
+type RouteInfo struct {
+	Host      string   `json:"host"` // lower cased like the rules match it
+	Port      int      `json:"port,omitempty"`
+	Network   string   `json:"network"`
+	Profile   string   `json:"profile,omitempty"`
+	Resolved  string   `json:"resolved,omitempty"` // address from the hosts file, if it overrides host
+	Pattern   string   `json:"pattern,omitempty"`  // host pattern of the rule, empty if none matched
+	Match     string   `json:"match,omitempty"`    // how the pattern matched: exact, zone or cidr
+	Filter    string   `json:"filter,omitempty"`
+	Rule      string   `json:"rule,omitempty"`      // number of the rule, empty for a proxy alias
+	Rewritten string   `json:"rewritten,omitempty"` // host dialed through a *.label.pacman alias
+	Action    Action   `json:"action"`
+	Proxies   []string `json:"proxies,omitempty"`
+	Strategy  Strategy `json:"strategy,omitempty"`
+}
+
+// APIHandler serves the control API.
+func (pacman *PACMan) APIHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+APIPrefix+"proxies", pacman.apiProxies)
+	mux.HandleFunc("GET "+APIPrefix+"proxies/{label}", pacman.apiProxy)
+	mux.HandleFunc("POST "+APIPrefix+"proxies/{label}/{action}", pacman.apiProxyAction)
+	mux.HandleFunc("POST "+APIPrefix+"reload", pacman.apiReload)
+	mux.HandleFunc("GET "+APIPrefix+"route", pacman.apiRoute)
+	mux.HandleFunc("GET "+APIPrefix+"events", pacman.apiEvents)
+	mux.HandleFunc("GET "+APIPrefix+"connections", pacman.apiConnections)
+	mux.HandleFunc("DELETE "+APIPrefix+"connections", pacman.apiCloseConnections)
+	mux.HandleFunc("DELETE "+APIPrefix+"connections/{id}", pacman.apiCloseConnection)
+	mux.HandleFunc(APIPrefix, func(w http.ResponseWriter, r *http.Request) {
+		apiError(w, http.StatusNotFound, fmt.Errorf("no such endpoint: %s %s", r.Method, r.URL.Path))
+	})
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+		default:
+			if r.Header.Get(APIRequestHeader) == "" {
+				apiError(w, http.StatusForbidden, fmt.Errorf("%s requests need the %s header", r.Method, APIRequestHeader))
+				return
+			}
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// requireHost refuses requests for a host the listener is not known by.
+func requireHost(h http.Handler, knownAs func(host string) bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if hh, _, err := net.SplitHostPort(host); err == nil {
+			host = hh
+		}
+		if !knownAs(strings.Trim(host, "[]")) {
+			apiError(w, http.StatusForbidden, fmt.Errorf("requests for host %q are not allowed", r.Host))
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+func (pacman *PACMan) apiProxies(w http.ResponseWriter, r *http.Request) {
+	pacman.mu.Lock()
+	proxies := make([]ProxyStatus, 0, len(pacman.pool))
+	for _, pd := range iterutil.SortedMapIter(pacman.pool) {
+		proxies = append(proxies, pd.Status())
+	}
+	pacman.mu.Unlock()
+	writeJSON(w, http.StatusOK, proxies)
+}
+
+func (pacman *PACMan) apiProxy(w http.ResponseWriter, r *http.Request) {
+	pd, err := pacman.pooled(r.PathValue("label"))
+	if err != nil {
+		apiError(w, http.StatusNotFound, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, pd.Status())
+}
+
+func (pacman *PACMan) apiProxyAction(w http.ResponseWriter, r *http.Request) {
+	pd, err := pacman.pooled(r.PathValue("label"))
+	if err != nil {
+		apiError(w, http.StatusNotFound, err)
+		return
+	}
+	switch action := r.PathValue("action"); action {
+	case "connect":
+		if state, _ := pd.State(); state == dialer.Failed {
+			apiError(w, http.StatusConflict, fmt.Errorf("proxy %s failed, reset it to connect", pd.Label))
+			return
+		}
+		pd.dialer.Connect()
+	case "disconnect":
+		pd.dialer.Close()
+	case "reset":
+		pd.dialer.Reset()
+	default:
+		apiError(w, http.StatusNotFound, fmt.Errorf("unknown action %q, expected connect, disconnect or reset", action))
+		return
+	}
+	writeJSON(w, http.StatusOK, pd.Status())
+}
+
+func (pacman *PACMan) apiReload(w http.ResponseWriter, r *http.Request) {
+	if err := pacman.reloadConfig(); err != nil {
+		apiError(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, struct{}{})
+}
+
+// apiRoute takes the host, or host:port, and optionally the port,
+// network and profile as query parameters.
+func (pacman *PACMan) apiRoute(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	host, port := q.Get("host"), 0
+	if h, p, err := net.SplitHostPort(host); err == nil {
+		host = h
+		q.Set("port", cmp.Or(q.Get("port"), p))
+	}
+	if p := q.Get("port"); p != "" {
+		n, err := strconv.ParseUint(p, 10, 16)
+		if err != nil {
+			apiError(w, http.StatusBadRequest, fmt.Errorf("invalid port %q", p))
+			return
+		}
+		port = int(n)
+	}
+	if host == "" {
+		apiError(w, http.StatusBadRequest, errors.New("missing host"))
+		return
+	}
+	network := q.Get("network")
+	if network == "" {
+		network = "tcp"
+	}
+
+	ri, err := pacman.Route(r.Context(), q.Get("profile"), network, host, port)
+	if err != nil {
+		apiError(w, http.StatusNotFound, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, ri)
+}
+
+// apiEvents streams the state changes of the proxies as Server-Sent Events.
+// The id of an event is its number in the stream, so a client reconnecting
+// with Last-Event-ID, or passing it as the since parameter, gets the changes
+// it missed while they are still logged. since=0 replays all of them, as
+// does an id beyond the last event, which is from before a restart.
+// The proxy parameter limits the stream to one proxy.
+func (pacman *PACMan) apiEvents(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	since := pacman.events.Last()
+	id := r.Header.Get("Last-Event-ID")
+	if q.Has("since") {
+		id = q.Get("since")
+	}
+	if id != "" {
+		var err error
+		if since, err = strconv.ParseUint(id, 10, 64); err != nil {
+			apiError(w, http.StatusBadRequest, fmt.Errorf("invalid event id %q", id))
+			return
+		}
+		// an id of a previous run of PACman
+		if since > pacman.events.Last() {
+			since = 0
+		}
+	}
+	proxy := q.Get("proxy")
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	for e := range pacman.events.Follow(r.Context(), since) {
+		if proxy != "" && e.Value.Proxy != proxy {
+			continue
+		}
+		data, err := json.Marshal(e.Value)
+		if err != nil {
+			continue
+		}
+		if _, err := fmt.Fprintf(w, "id: %d\nevent: state\ndata: %s\n\n", e.Seq, data); err != nil {
+			return
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// apiConnections lists the open connections, only those through
+// a proxy if the proxy parameter is given.
+func (pacman *PACMan) apiConnections(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, pacman.conns.List(r.URL.Query().Get("proxy")))
+}
+
+// apiCloseConnections closes the connections through the proxy given as
+// a parameter, or all of them without one, and returns what it closed.
+func (pacman *PACMan) apiCloseConnections(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, pacman.conns.CloseProxy(r.URL.Query().Get("proxy"), errClosed))
+}
+
+func (pacman *PACMan) apiCloseConnection(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		apiError(w, http.StatusBadRequest, fmt.Errorf("invalid connection id %q", r.PathValue("id")))
+		return
+	}
+	c, ok := pacman.conns.Close(id)
+	if !ok {
+		apiError(w, http.StatusNotFound, fmt.Errorf("no such connection: %d", id))
+		return
+	}
+	writeJSON(w, http.StatusOK, c)
+}
+
+// pooled returns the pooled dialer of a label.
+func (pacman *PACMan) pooled(label string) (*PooledDialer, error) {
+	pacman.mu.Lock()
+	defer pacman.mu.Unlock()
+	pd, ok := pacman.pool[label]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrProxyNotFound, label)
+	}
+	return pd, nil
+}
+
+// Route reports how a connection from a listener of the profile
+// would be routed.
+func (pacman *PACMan) Route(ctx context.Context, profile, network, host string, port int) (*RouteInfo, error) {
+	bh := pacman.profileDialer(profile)
+	if bh == nil {
+		return nil, fmt.Errorf("profile %q is not defined", profile)
+	}
+	host = trie.Canonical(host)
+	ri := RouteInfo{
+		Host:    host,
+		Port:    port,
+		Network: network,
+		Profile: profile,
+		Action:  ActionDirect,
+	}
+	route, resolved, ok := bh.Route(ctx, network, host, port)
+	if resolved != host {
+		ri.Resolved = resolved
+	}
+	if !ok {
+		return &ri, nil
+	}
+	ri.Pattern, ri.Match, ri.Filter = route.Pattern, route.Kind.String(), route.Filter.String()
+
+	pacman.mu.Lock()
+	defer pacman.mu.Unlock()
+	i := pacman.ruleFor(profile, route)
+	if i < 0 {
+		// the *.label.pacman alias of a proxy
+		suffix := strings.TrimPrefix(route.Pattern, ".")
+		label := strings.TrimSuffix(suffix, ".pacman")
+		ri.Action, ri.Proxies = ActionProxy, []string{label}
+		ri.Rewritten = strings.TrimSuffix(strings.TrimSuffix(host, suffix), ".")
+		return &ri, nil
+	}
+	rule := pacman.cfg.rules(profile)[i]
+	ri.Rule = ruleName(profile, i)
+	ri.Action, ri.Proxies, ri.Strategy = rule.Action, rule.Proxies, rule.Strategy
+	if ri.Action == "" {
+		ri.Action = ActionProxy
+	}
+	if ri.Action == ActionProxy && len(ri.Proxies) == 0 {
+		ri.Action = ActionDirect
+	}
+	return &ri, nil
+}
+
+// ruleFor finds the index of the rule that added a route to the ruleset
+// of a profile, or -1 if no rule did. Later rules replace earlier ones,
+// so the last rule with the pattern and filter wins. Must be called with
+// the lock held.
+func (pacman *PACMan) ruleFor(profile string, route dialer.Route) int {
+	filter := route.Filter.String()
+	for i, r := range slices.Backward(pacman.cfg.rules(profile)) {
+		if r.Filter().String() != filter {
+			continue
+		}
+		match := func(h string) bool { return strings.EqualFold(h, route.Pattern) }
+		if slices.ContainsFunc(r.Hosts, match) {
+			return i
+		}
+		for _, hl := range r.HostLists {
+			if slices.ContainsFunc(pacman.lists[hl.key()].Hosts(), match) {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// Status returns the state of the proxy.
+func (pd *PooledDialer) Status() ProxyStatus {
+	state, err := pd.State()
+	s := ProxyStatus{
+		Label: pd.Label,
+		URL:   pd.URL.Redacted(),
+		State: state.String(),
+	}
+	if err != nil {
+		s.Error = err.Error()
+	}
+	return s
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+// APIError is the body of a failed API request.
+type APIError struct {
+	Error string `json:"error"`
+}
+
+func apiError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, APIError{Error: err.Error()})
+}
+
+// ControlClient returns a client of the control API of a running PACMan
+// and the base URL of the API. address overrides where the API is found,
+// as host:port or unix:/path. By default the control socket of the config
+// is used, or else the first TCP listener serving the API, preferring one
+// without auth. The API of a listener with auth is called with the
+// credentials of the first user of the config.
+func ControlClient(cfg *Config, address string) (*http.Client, string, error) {
+	var addr netutil.ListenAddr
+	switch {
+	case address != "":
+		if err := addr.UnmarshalText([]byte(address)); err != nil {
+			return nil, "", err
+		}
+	case cfg.Control != "":
+		addr = netutil.ListenAddr{Network: "unix", Address: string(cfg.Control)}
+	default:
+		var lc *Listener
+		for _, l := range cfg.listeners() {
+			if l.Address.Network == "tcp" && l.serves(ProtocolAPI) && (lc == nil || lc.Auth && !l.Auth) {
+				lc = l
+			}
+		}
+		if lc == nil {
+			return nil, "", errors.New("no listener serves the control API, set control_socket or add the api protocol to a listener")
+		}
+		addr = lc.Address
+	}
+
+	var (
+		transport http.RoundTripper
+		base      string
+	)
+	if addr.Network == "unix" {
+		path, err := Path(addr.Address).ExpandUser()
+		if err != nil {
+			return nil, "", err
+		}
+		transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", path)
+			},
+		}
+		base = "http://pacman" + APIPrefix
+	} else {
+		host, port, err := net.SplitHostPort(addr.Address)
+		if err != nil {
+			return nil, "", err
+		}
+		if ip := net.ParseIP(host); host == "" || ip != nil && ip.IsUnspecified() {
+			host = "127.0.0.1"
+		}
+		transport = http.DefaultTransport
+		base = "http://" + net.JoinHostPort(host, port) + APIPrefix
+	}
+
+	authed := slices.ContainsFunc(cfg.listeners(), func(l *Listener) bool {
+		return l.Auth && l.Address == addr
+	})
+	if authed && cfg.Auth != nil && len(cfg.Auth.Users) > 0 {
+		u := cfg.Auth.Users[0]
+		password, err := u.password(context.Background())
+		if err != nil {
+			return nil, "", err
+		}
+		transport = &basicAuthTransport{RoundTripper: transport, username: u.Username, password: password}
+	}
+	return &http.Client{Transport: transport}, base, nil
+}
+
+// basicAuthTransport sends requests with Basic credentials.
+type basicAuthTransport struct {
+	http.RoundTripper
+	username, password string
+}
+
+func (t *basicAuthTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.SetBasicAuth(t.username, t.password)
+	return t.RoundTripper.RoundTrip(r)
+}
+
+// CallAPI sends a request to the control API and returns the response
+// body. A failed request returns the error reported by the API.
+func CallAPI(ctx context.Context, client *http.Client, method, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(APIRequestHeader, "1")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		var e APIError
+		if json.Unmarshal(body, &e) == nil && e.Error != "" {
+			return nil, errors.New(e.Error)
+		}
+		return nil, fmt.Errorf("%s %s: %s", method, url, resp.Status)
+	}
+	return body, nil
+}
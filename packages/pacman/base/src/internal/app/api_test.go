@@ -0,0 +1,190 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/proxy"
+
+	"github.com/gilliginsisland/pacman/pkg/dialer"
+	"github.com/gilliginsisland/pacman/pkg/netutil"
+)
+
+func TestAPIHandler(t *testing.T) {
+	failed := &PooledDialer{
+		Label: "vpn",
+		URL:   &URL{URL: url.URL{Scheme: "socks5", Host: "127.0.0.1:1080"}},
+		dialer: dialer.NewLazy(func(ctx context.Context) (proxy.Dialer, error) {
+			return nil, errors.New("not dialed by the test")
+		}, 0),
+	}
+	failed.state.Store(dialer.StateSignal{State: dialer.Failed, Err: errors.New("down")})
+	pacman := &PACMan{pool: DialerPool{"vpn": failed}}
+	h := pacman.APIHandler()
+
+	tests := []struct {
+		method string
+		path   string
+		header bool // send APIRequestHeader
+		expect int
+	}{
+		{http.MethodGet, "proxies/vpn", false, http.StatusOK},
+		{http.MethodPost, "proxies/vpn/connect", false, http.StatusForbidden},
+		{http.MethodPost, "reload", false, http.StatusForbidden},
+		{http.MethodDelete, "connections/1", false, http.StatusForbidden},
+		{http.MethodPost, "proxies/vpn/connect", true, http.StatusConflict},
+		{http.MethodPost, "proxies/missing/connect", true, http.StatusNotFound},
+		{http.MethodGet, "route?host=example.com:99999", false, http.StatusBadRequest},
+		{http.MethodGet, "route?host=example.com:https", false, http.StatusBadRequest},
+		{http.MethodGet, "route?port=-1&host=example.com", false, http.StatusBadRequest},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.method+" "+tc.path, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, APIPrefix+tc.path, nil)
+			if tc.header {
+				req.Header.Set(APIRequestHeader, "1")
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tc.expect {
+				t.Errorf("got status %d (%s), want %d", rec.Code, rec.Body, tc.expect)
+			}
+		})
+	}
+}
+
+func TestAPIEventsAfterRestart(t *testing.T) {
+	var pacman PACMan
+	pacman.events.Append(ProxyEvent{Proxy: "vpn", State: dialer.Online.String()})
+
+	tests := []struct {
+		lastEventID string
+		replayed    bool
+	}{
+		{"1", false},
+		{"0", true},
+		{"100", true}, // from before a restart
+	}
+	for _, tc := range tests {
+		t.Run(tc.lastEventID, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			req := httptest.NewRequest(http.MethodGet, APIPrefix+"events", nil).WithContext(ctx)
+			req.Header.Set("Last-Event-ID", tc.lastEventID)
+			rec := httptest.NewRecorder()
+			pacman.APIHandler().ServeHTTP(rec, req)
+			if got := strings.Contains(rec.Body.String(), "id: 1\n"); got != tc.replayed {
+				t.Errorf("got replayed %v, want %v:\n%s", got, tc.replayed, rec.Body)
+			}
+		})
+	}
+}
+
+func TestControlClientAuth(t *testing.T) {
+	var got string
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("Authorization")
+		writeJSON(w, http.StatusOK, []ProxyStatus{})
+	}))
+	defer api.Close()
+	addr := api.Listener.Addr().String()
+
+	tests := []struct {
+		name      string
+		listeners string
+		expect    string
+	}{
+		{
+			name: "auth",
+			listeners: `
+  - address: ` + addr + `
+    protocols: [api]
+    auth: true`,
+			expect: "Basic YWxpY2U6c2VjcmV0", // alice:secret
+		},
+		{
+			name: "without auth preferred",
+			listeners: `
+  - address: 127.0.0.1:1
+    protocols: [api]
+    auth: true
+  - address: ` + addr + `
+    protocols: [api]`,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := parseConfig(t, `listeners:`+tc.listeners+`
+auth:
+  users:
+    - username: alice
+      password: secret
+`)
+			client, base, err := ControlClient(cfg, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := CallAPI(context.Background(), client, http.MethodGet, base+"proxies"); err != nil {
+				t.Fatal(err)
+			}
+			if got != tc.expect {
+				t.Errorf("got Authorization %q, want %q", got, tc.expect)
+			}
+		})
+	}
+}
+
+func TestAPIListener(t *testing.T) {
+	tcp := &Listener{
+		Address:   netutil.ListenAddr{Network: "tcp", Address: "pacman.test:11079"},
+		Protocols: Protocols{ProtocolAPI},
+		TLS:       &ListenerTLS{Names: []string{"pacman.lan"}},
+	}
+	h := requireHost(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), tcp.knownAs)
+
+	hosts := []struct {
+		host   string
+		expect int
+	}{
+		{"localhost:11079", http.StatusOK},
+		{"127.0.0.1:11079", http.StatusOK},
+		{"[::1]:11079", http.StatusOK},
+		{"192.0.2.1:11079", http.StatusOK},
+		{"pacman.test:11079", http.StatusOK},
+		{"PACMAN.LAN.", http.StatusOK},
+		{"rebound.example:11079", http.StatusForbidden},
+	}
+	for _, tc := range hosts {
+		t.Run(tc.host, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, APIPrefix+"proxies", nil)
+			req.Host = tc.host
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tc.expect {
+				t.Errorf("got status %d, want %d", rec.Code, tc.expect)
+			}
+		})
+	}
+
+	// the API is opt-in on TCP listeners
+	listeners := []struct {
+		listener *Listener
+		expect   bool
+	}{
+		{tcp, true},
+		{&Listener{Address: netutil.ListenAddr{Network: "tcp", Address: "127.0.0.1:11078"}}, false},
+		{&Listener{Address: netutil.ListenAddr{Network: "unix", Address: "/tmp/pacman.sock"}}, true},
+	}
+	for _, tc := range listeners {
+		if got := tc.listener.serves(ProtocolAPI); got != tc.expect {
+			t.Errorf("listener %s serves api: got %v, want %v", tc.listener, got, tc.expect)
+		}
+	}
+}
@@ -1,152 +1,132 @@
 package app
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"log/slog"
 	"net"
+	"net/http"
 	"net/url"
 	"os"
 	"os/signal"
+	"reflect"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"golang.org/x/net/proxy"
+	"golang.org/x/sync/errgroup"
 
-	"github.com/gilliginsisland/pacman/docs"
 	"github.com/gilliginsisland/pacman/pkg/dialer"
-	"github.com/gilliginsisland/pacman/pkg/dialer/oc"
-	"github.com/gilliginsisland/pacman/pkg/menuet"
+	"github.com/gilliginsisland/pacman/pkg/iterutil"
 	"github.com/gilliginsisland/pacman/pkg/netutil"
-	"github.com/gilliginsisland/pacman/pkg/notify"
+	"github.com/gilliginsisland/pacman/pkg/syncutil"
+	"github.com/gilliginsisland/pacman/pkg/watch"
 	"github.com/gilliginsisland/pacman/pkg/xdg"
 )
 
 type PACMan struct {
-	config   Path
-	pool     DialerPool
-	dialer   dialer.ByHost
-	listener net.Listener
-	server   netutil.Server
-	menu     menuet.StatusItem
-	mu       sync.Mutex
+	config    Path
+	pool      DialerPool
+	dialer    dialer.ByHost             // top level rules, also used to reach the proxies
+	profiles  map[string]*dialer.ByHost // rules of each profile
+	listeners []*Listener               // listeners being served
+	lists     map[string]*runningList   // host lists by HostList.key
+	latencies dialer.Latencies          // dial times for the least-latency strategy
+	events    syncutil.Log[ProxyEvent]  // state changes of all proxies
+	conns     ConnTable                 // connections dialed by the listeners
+	auth      atomic.Pointer[Auth]      // client credentials of the current config
+	ui        UI
+	cfg       *Config
+	watcher   *watch.Watcher
+	mu        sync.Mutex
 }
 
-func Run(config Path, l net.Listener) error {
-	var err error
-	app := menuet.App()
-	app.SetNotificationCategories(oc.NotificationCategories)
-	app.Run(func() {
-		defer app.Terminate()
-		err = run(config, l)
-		if err == nil {
-			return
+func Run(config Path, l net.Listener, ui UI) error {
+	return ui.Run(func() error {
+		err := run(config, l, ui)
+		if err != nil {
+			slog.Error("application terminated:", slog.Any("error", err))
+			ui.Alert("Application Terminated", err)
 		}
-		slog.Error("application terminated:", slog.Any("error", err))
-		notify.Notify(notify.Notification{
-			Subtitle: "Application Terminated",
-			Body:     err.Error(),
-		})
-		app.Terminate()
+		return err
 	})
-	return err
 }
 
-func run(config Path, l net.Listener) error {
-	app := menuet.App()
-
+func run(config Path, l net.Listener, ui UI) error {
 	cfg, err := ParseConfigFile(config)
 	if err != nil {
 		return err
 	}
 
-	if l == nil {
-		if cfg.Listen == "" {
-			return errors.New("no listener provided")
-		} else {
-			if l, err = net.Listen("tcp", cfg.Listen.String()); err != nil {
-				return err
+	listeners := cfg.listeners()
+	if l != nil {
+		// a socket passed by launchd replaces the configured listeners
+		listeners = []*Listener{{
+			Address: netutil.ListenAddr{Network: l.Addr().Network(), Address: l.Addr().String()},
+		}}
+	}
+	if len(listeners) == 0 {
+		return errors.New("no listener provided")
+	}
+
+	nls := make([]net.Listener, len(listeners))
+	for i, lc := range listeners {
+		if l != nil {
+			nls[i] = l
+			continue
+		}
+		if nls[i], err = lc.Listen(); err != nil {
+			for _, nl := range nls[:i] {
+				nl.Close()
 			}
+			return err
 		}
 	}
 
 	pacman := PACMan{
-		config:   config,
-		listener: l,
+		config:    config,
+		listeners: listeners,
 		dialer: dialer.ByHost{
-			Default: &net.Dialer{
-				Timeout: 5 * time.Second,
-			},
-		},
-		pool: make(DialerPool),
-	}
-	pacman.menu = menuet.StatusItem{
-		Title: "PACman",
-		Image: "menuicon.pdf",
-		Submenu: menuet.Sections{
-			&menuet.Section{
-				Title: "Server Address",
-				Content: menuet.DynamicItem(func() menuet.Itemer {
-					return &menuet.MenuItem{
-						Text: pacman.listener.Addr().String(),
-					}
-				}),
-			},
-			menuet.DynamicItem(func() menuet.Itemer {
-				if len(pacman.pool) == 0 {
-					return &menuet.MenuItem{
-						Text: "No Proxies Configured",
-					}
-				}
-				return &menuet.Section{
-					Title:   "Proxies",
-					Content: pacman.pool.MenuItems(),
-				}
-			}),
-			&menuet.MenuItem{
-				Text: "Settings",
-				Submenu: (menuet.MenuItems{
-					&menuet.MenuItem{
-						Text:    "Edit",
-						Clicked: pacman.OpenConfig,
-					},
-					&menuet.MenuItem{
-						Text:    "Reload",
-						Clicked: pacman.ReloadConfig,
-					},
-					&menuet.MenuItemSeparator{},
-					&menuet.MenuItem{
-						Text: "Help",
-						Clicked: func() {
-							menuet.WebView(docs.HTML)
-						},
-					},
-					menuet.DynamicItem(func() menuet.Itemer {
-						action := &SparkleUpdateAction{}
-						if !app.HasAction(action) {
-							return nil
-						}
-						return &menuet.MenuItems{
-							&menuet.MenuItemSeparator{},
-							&menuet.MenuItem{
-								Text:    "Check for Updates...",
-								Clicked: func() { app.InvokeAction(action) },
-							},
-						}
-					}),
-				}),
-			},
-			&menuet.MenuItem{
-				Text:    "Quit",
-				Clicked: app.Terminate,
-			},
+			Default: directDialer,
 		},
+		profiles: make(map[string]*dialer.ByHost),
+		pool:     make(DialerPool),
+		ui:       ui,
+	}
+	pacman.registerMetrics()
+	if cfg.AccessLog != nil {
+		if w, err := cfg.AccessLog.open(); err != nil {
+			slog.Warn("access log disabled", slog.Any("error", err))
+		} else {
+			defer w.Close()
+			pacman.conns.Log = w
+		}
 	}
-	pacman.server = NewProxyServer(&pacman.dialer)
+
 	if err = pacman.LoadConfig(cfg); err != nil {
+		for _, nl := range nls {
+			nl.Close()
+		}
 		return err
 	}
 
+	if pacman.watcher, err = watch.New(500 * time.Millisecond); err != nil {
+		slog.Warn("config file watching disabled", slog.Any("error", err))
+	} else {
+		defer pacman.watcher.Close()
+		pacman.watch()
+		go func() {
+			for range pacman.watcher.C {
+				slog.Info("config file changed, reloading")
+				pacman.ReloadConfig()
+			}
+		}()
+	}
+
 	signalCh := make(chan os.Signal, 1)
 	defer close(signalCh)
 	signal.Notify(signalCh, syscall.SIGHUP)
@@ -157,12 +137,74 @@ func run(config Path, l net.Listener) error {
 		}
 	}()
 
-	slog.Info("PACman server listening", slog.String("address", l.Addr().String()))
-	err = pacman.server.Serve(l)
+	// stop every listener once one of them fails
+	g, ctx := errgroup.WithContext(context.Background())
+	api := pacman.APIHandler()
+	if cfg.Control != "" {
+		if cl, err := listenControl(cfg.Control); err != nil {
+			slog.Warn("control socket disabled", slog.Any("error", err))
+		} else {
+			context.AfterFunc(ctx, func() { cl.Close() })
+			srv := &http.Server{Handler: api}
+			g.Go(func() error {
+				slog.Info("control API listening", slog.String("address", cl.Addr().String()))
+				return srv.Serve(cl)
+			})
+		}
+	}
+	for i, lc := range listeners {
+		nl := nls[i]
+		context.AfterFunc(ctx, func() { nl.Close() })
+		var auth func() *Auth
+		if lc.Auth {
+			auth = pacman.auth.Load
+		}
+		srv := NewProxyServer(pacman.profileDialer(lc.Profile), &pacman.conns, lc, auth, api)
+		srv.Allow(lc.allowClient())
+		g.Go(func() error {
+			slog.Info("PACman server listening",
+				slog.String("address", nl.Addr().String()),
+				slog.Any("protocols", lc.Protocols),
+				slog.String("profile", lc.Profile),
+			)
+			return srv.Serve(nl)
+		})
+	}
+	err = g.Wait()
 	slog.Info("PACman proxy server stopped", slog.Any("error", err))
 	return err
 }
 
+// listenControl opens the control socket, only accessible to the user.
+func listenControl(path Path) (net.Listener, error) {
+	l := Listener{Address: netutil.ListenAddr{Network: "unix", Address: string(path)}}
+	nl, err := l.Listen()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(nl.Addr().String(), 0o600); err != nil {
+		nl.Close()
+		return nil, err
+	}
+	return nl, nil
+}
+
+// directDialer connects to hosts not matched by any rule.
+var directDialer = &net.Dialer{
+	Timeout: 5 * time.Second,
+}
+
+// profileDialer returns the dialer applying the rules of a profile.
+// The empty profile uses the top level rules.
+func (pacman *PACMan) profileDialer(profile string) *dialer.ByHost {
+	pacman.mu.Lock()
+	defer pacman.mu.Unlock()
+	if profile == "" {
+		return &pacman.dialer
+	}
+	return pacman.profiles[profile]
+}
+
 func (pacman *PACMan) OpenConfig() {
 	p, err := pacman.config.ExpandUser()
 	if err != nil {
@@ -177,90 +219,238 @@ func (pacman *PACMan) OpenConfig() {
 }
 
 func (pacman *PACMan) ReloadConfig() {
+	if err := pacman.reloadConfig(); err != nil {
+		pacman.ui.Alert("Config Reload Error", err)
+		return
+	}
+	pacman.ui.Notify("Config Reloaded", "The configuration was successfully reloaded.")
+}
+
+func (pacman *PACMan) reloadConfig() error {
 	cfg, err := ParseConfigFile(pacman.config)
 	if err == nil {
 		err = pacman.LoadConfig(cfg)
 	}
 	if err != nil {
-		notify.Notify(notify.Notification{
-			Title: "Config Reload Error",
-			Body:  err.Error(),
-		})
-		return
+		return err
 	}
-	notify.Notify(notify.Notification{
-		Subtitle: "Config Reloaded",
-		Body:     "The configuration was successfully reloaded.",
-	})
+	pacman.watch()
+	return nil
 }
 
-func (pacman *PACMan) LoadConfig(cfg *Config) error {
+func (pacman *PACMan) LoadConfig(cfg *Config) (err error) {
 	pacman.mu.Lock()
 	defer pacman.mu.Unlock()
 
-	var rs dialer.RuleSet
+	diff := DiffConfig(pacman.cfg, cfg)
+	reload := pacman.cfg != nil
+	defer func() {
+		if err != nil {
+			slog.Error("config rejected, keeping current ruleset", slog.Any("error", err), slog.Any("diff", diff))
+		} else if reload {
+			slog.Info("config reloaded", slog.Any("diff", diff))
+		}
+		switch {
+		case !reload:
+		case err != nil:
+			configReloads.With("failure").Inc()
+		default:
+			configReloads.With("success").Inc()
+		}
+	}()
+
+	// validate before touching the pool so a broken config leaves
+	// the current ruleset and dialers untouched
+	if err := cfg.check(); err != nil {
+		return err
+	}
+	for _, l := range pacman.listeners {
+		if _, ok := cfg.Profiles[l.Profile]; l.Profile != "" && !ok {
+			return fmt.Errorf("profile %q is used by listener %s", l.Profile, l)
+		}
+		if l.Auth && cfg.Auth == nil {
+			return fmt.Errorf("auth is used by listener %s", l)
+		}
+	}
+	if pacman.cfg != nil && !reflect.DeepEqual(pacman.cfg.listeners(), cfg.listeners()) {
+		slog.Warn("listener changes take effect after a restart")
+	}
+	if pacman.cfg != nil && !reflect.DeepEqual(pacman.cfg.AccessLog, cfg.AccessLog) {
+		slog.Warn("access log changes take effect after a restart")
+	}
 
 	for k, u := range cfg.Proxies {
 		pd := pacman.pool[k]
-		if pd == nil || pd.URL.String() != u.String() {
+		if pd == nil || !pd.URL.Equal(u) {
 			// close existing dialer after we update the dialer ruleset
 			if pd != nil {
+				pacman.latencies.Forget(pd.dial)
 				defer pd.Close()
 			}
-			pd = NewPooledDialer(k, &u.URL, &pacman.dialer)
+			pd = NewPooledDialer(k, u, &pacman.dialer)
 			pacman.pool[k] = pd
-			go pd.Track(pacman.UpdateMenu)
+			// from the first change, which may happen before Track runs
+			go pd.Track(0, func(e dialer.Event) {
+				pacman.events.Append(newProxyEvent(pd.Label, e))
+				if s := e.Value.State; s == dialer.Offline || s == dialer.Failed {
+					// connections do not outlive the proxy they went through
+					pacman.conns.CloseProxy(pd.Label, fmt.Errorf("proxy %s went %s", pd.Label, s))
+				}
+				pacman.UpdateUI()
+				pacman.ui.StateChanged(pd, e.Value.State, e.Value.Err)
+			})
 		}
+		pd.dialer.SetRetry(u.Reconnect.retry())
+		pd.SetHealthCheck(u.HealthCheck, &pacman.latencies)
+	}
 
-		// add the wildcard *.label.pacman
-		subdomain := k + ".pacman"
-		rs.Add("."+subdomain, &dialer.RewritingDialer{
-			Dialer: pd.dialer,
-			Suffix: subdomain,
-		})
+	pacman.cfg = cfg
+	pacman.auth.Store(cfg.Auth)
+	pacman.syncHostLists(cfg)
+	pacman.applyRules()
+
+	for k, pd := range pacman.pool {
+		if _, ok := cfg.Proxies[k]; ok {
+			continue
+		}
+		delete(pacman.pool, k)
+		pacman.latencies.Forget(pd.dial)
+		defer pd.Close()
+	}
+	go pacman.UpdateUI()
+
+	return nil
+}
+
+// ruleName numbers the rule at index i of a profile in the order
+// the rules are merged, starting at 1.
+func ruleName(profile string, i int) string {
+	name := strconv.Itoa(i + 1)
+	if profile != "" {
+		name = profile + "/" + name
+	}
+	return name
+}
+
+// check rejects a config whose rules cannot be applied.
+func (cfg *Config) check() error {
+	for label, u := range iterutil.SortedMapIter(cfg.Proxies) {
+		if _, err := ParseTimeout(&u.URL); err != nil {
+			return fmt.Errorf("proxy %s: %w", label, err)
+		}
 	}
+	for _, rules := range cfg.profiles {
+		for _, r := range rules {
+			if err := r.check(); err != nil {
+				return err
+			}
+			for _, proxy := range r.Proxies {
+				if _, ok := cfg.Proxies[proxy]; !ok {
+					return fmt.Errorf("%w: %s", ErrProxyNotFound, proxy)
+				}
+			}
+			for _, hl := range r.HostLists {
+				if _, ok := cfg.Proxies[hl.Proxy]; hl.Proxy != "" && !ok {
+					return fmt.Errorf("%w: %s", ErrProxyNotFound, hl.Proxy)
+				}
+			}
+		}
+	}
+	return checkLoops(cfg)
+}
 
-	for _, r := range cfg.Rules {
+// applyRules builds the ruleset of every profile from the current config
+// and installs them. Must be called with the lock held.
+func (pacman *PACMan) applyRules() {
+	for name, rules := range pacman.cfg.profiles {
+		var rs dialer.RuleSet
+
+		// the proxies are reachable as *.label.pacman under every profile
+		for k := range pacman.cfg.Proxies {
+			subdomain := k + ".pacman"
+			rs.Add("."+subdomain, &dialer.RewritingDialer{
+				Dialer: pacman.pool[k].dial,
+				Suffix: subdomain,
+			})
+		}
+		pacman.addRules(&rs, name, rules)
+
+		if name == "" {
+			pacman.dialer.Swap(&rs)
+			continue
+		}
+		bh, ok := pacman.profiles[name]
+		if !ok {
+			bh = &dialer.ByHost{Default: directDialer}
+			pacman.profiles[name] = bh
+		}
+		bh.Swap(&rs)
+	}
+}
+
+// addRules adds the rules of a profile to the ruleset, using the dialers
+// of the pool.
+func (pacman *PACMan) addRules(rs *dialer.RuleSet, profile string, rules []*Rule) {
+	for i, r := range rules {
 		chain := make([]proxy.ContextDialer, len(r.Proxies))
 		for i, proxy := range r.Proxies {
-			pd, ok := pacman.pool[proxy]
-			if !ok {
-				return errors.New("proxy not found: " + proxy)
-			}
-			chain[i] = pd.dialer
+			chain[i] = pacman.pool[proxy].dial
 		}
 
 		var xd proxy.ContextDialer
-		switch len(chain) {
-		case 0:
+		switch {
+		case r.Action == ActionDirect:
+			xd = proxy.Direct
+		case r.Action == ActionReject:
+			xd = dialer.Reject
+		case r.Action == ActionDrop:
+			xd = dialer.Drop
+		case len(chain) == 0:
 			xd = nil
-		case 1:
+		case len(chain) == 1:
 			xd = chain[0]
+		case r.Strategy == StrategyRace:
+			xd = &dialer.Race{Dialers: chain}
+		case r.Strategy == StrategyRoundRobin:
+			xd = &dialer.RoundRobin{Dialers: chain}
+		case r.Strategy == StrategyLeastLatency:
+			xd = &dialer.LeastLatency{Dialers: chain, Latencies: &pacman.latencies}
 		default:
 			xd = dialer.Chain(chain)
 		}
+		if xd != nil {
+			xd = &ruleDialer{rule: ruleName(profile, i), dialer: xd}
+		}
 
 		for _, h := range r.Hosts {
-			rs.Add(h, xd)
+			rs.AddFiltered(h, r.Filter(), xd)
 		}
-	}
-
-	pacman.dialer.Swap(&rs)
-
-	for k, pd := range pacman.pool {
-		if _, ok := cfg.Proxies[k]; ok {
-			continue
+		for _, hl := range r.HostLists {
+			for _, h := range pacman.lists[hl.key()].Hosts() {
+				rs.AddFiltered(h, r.Filter(), xd)
+			}
 		}
-		delete(pacman.pool, k)
-		defer pd.Close()
 	}
-	go pacman.UpdateMenu()
+}
 
-	return nil
+// watch points the config watcher at the files of the current config.
+func (pacman *PACMan) watch() {
+	if pacman.watcher == nil {
+		return
+	}
+	pacman.mu.Lock()
+	paths, err := pacman.cfg.Files()
+	pacman.mu.Unlock()
+	if err == nil {
+		err = pacman.watcher.Set(paths...)
+	}
+	if err != nil {
+		slog.Warn("failed to watch config files", slog.Any("error", err))
+	}
 }
 
-func (pacman *PACMan) UpdateMenu() {
+func (pacman *PACMan) UpdateUI() {
 	pacman.mu.Lock()
 	defer pacman.mu.Unlock()
-	menuet.App().UpdateStatusItem(&pacman.menu)
+	pacman.ui.Update(pacman)
 }
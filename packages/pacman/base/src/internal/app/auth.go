@@ -0,0 +1,127 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/gilliginsisland/pacman/pkg/secret"
+)
+
+// Auth is the credentials clients present to listeners with auth enabled.
+type Auth struct {
+	Users          []*User `json:"users"`           // socks5 and HTTP proxy clients
+	AuthorizedKeys Path    `json:"authorized_keys"` // keys of SSH clients, in the OpenSSH format
+}
+
+// User is a username and password accepted by the socks5
+// and HTTP proxy listeners.
+type User struct {
+	Username string
+	// Password is looked up on the first login of the user,
+	// instead of a password given in plain text.
+	Password secret.Source
+
+	plain    string
+	mu       sync.Mutex
+	resolved bool
+}
+
+var _ json.Unmarshaler = (*User)(nil)
+
+func (u *User) UnmarshalJSON(data []byte) error {
+	var p struct {
+		Username string `json:"username"`
+		passwordFields
+	}
+	if err := json.Unmarshal(data, &p); err != nil {
+		return err
+	}
+	if p.Username == "" {
+		return fmt.Errorf("user without a username")
+	}
+	src, err := p.source()
+	if err != nil {
+		return fmt.Errorf("user %s: %w", p.Username, err)
+	}
+	if src == nil && p.Password == "" {
+		return fmt.Errorf("user %s: no password", p.Username)
+	}
+	u.Username, u.Password, u.plain = p.Username, src, p.Password
+	return nil
+}
+
+// password returns the password of the user. A password from a
+// secret source is looked up once, and again after a failure.
+func (u *User) password(ctx context.Context) (string, error) {
+	if u.Password == nil {
+		return u.plain, nil
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if !u.resolved {
+		password, err := u.Password.Secret(ctx)
+		if err != nil {
+			return "", fmt.Errorf("failed to resolve password from %s: %w", u.Password, err)
+		}
+		u.plain, u.resolved = password, true
+	}
+	return u.plain, nil
+}
+
+// Password reports whether the username and password match a user.
+func (a *Auth) Password(username, password string) bool {
+	if a == nil {
+		return false
+	}
+	for _, u := range a.Users {
+		if u.Username != username {
+			continue
+		}
+		want, err := u.password(context.Background())
+		if err != nil {
+			slog.Error("client authentication failed", slog.String("username", username), slog.Any("error", err))
+			return false
+		}
+		return subtle.ConstantTimeCompare([]byte(want), []byte(password)) == 1
+	}
+	return false
+}
+
+// PublicKey reports whether the key is in the authorized keys file.
+// The file is read on every login, so keys can be added and removed
+// without reloading the config.
+func (a *Auth) PublicKey(key ssh.PublicKey) bool {
+	if a == nil || a.AuthorizedKeys == "" {
+		return false
+	}
+	path, err := a.AuthorizedKeys.ExpandUser()
+	if err != nil {
+		return false
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		slog.Error("failed to read authorized keys", slog.String("path", path), slog.Any("error", err))
+		return false
+	}
+	want := key.Marshal()
+	for len(data) > 0 {
+		var authorized ssh.PublicKey
+		authorized, _, _, data, err = ssh.ParseAuthorizedKey(data)
+		if err != nil {
+			// no more keys
+			return false
+		}
+		if bytes.Equal(authorized.Marshal(), want) {
+			return true
+		}
+	}
+	return false
+}
@@ -1,38 +1,207 @@
 package app
 
 import (
+	"context"
+	"encoding"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"io/fs"
+	"maps"
 	"net/url"
 	"os"
 	"path"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/gilliginsisland/pacman/docs"
+	"github.com/gilliginsisland/pacman/pkg/dialer"
+	"github.com/gilliginsisland/pacman/pkg/iterutil"
 	"github.com/gilliginsisland/pacman/pkg/netutil"
+	"github.com/gilliginsisland/pacman/pkg/secret"
 	"sigs.k8s.io/yaml"
 )
 
 var ErrProxyNotFound = errors.New("proxy not found")
 
 type Config struct {
-	Path    Path
-	Listen  netutil.HostPort `json:"listen"`
-	Proxies map[string]*URL  `json:"proxies"`
-	Rules   []*Rule          `json:"rules"`
+	Path      Path
+	source    []byte
+	parts     []*Config          // files merged into the config, main file first
+	dirs      []string           // directories searched for includes
+	Listen    netutil.HostPort   `json:"listen"` // shorthand for a single listener
+	Listeners []*Listener        `json:"listeners"`
+	Control   Path               `json:"control_socket"` // Unix socket serving the control API
+	AccessLog *AccessLog         `json:"access_log"`     // one line per connection
+	Auth      *Auth              `json:"auth"`           // credentials of clients of listeners with auth
+	Include   []string           `json:"include"`
+	Proxies   map[string]*URL    `json:"proxies"`
+	Rules     []*Rule            `json:"rules"`
+	Profiles  map[string][]*Rule `json:"profiles"` // alternative rules for listeners
+}
+
+// listeners returns the configured listeners, including the one set by listen.
+func (cfg *Config) listeners() []*Listener {
+	if cfg.Listen == "" {
+		return cfg.Listeners
+	}
+	return append([]*Listener{{
+		Address: netutil.ListenAddr{Network: "tcp", Address: string(cfg.Listen)},
+	}}, cfg.Listeners...)
+}
+
+// rules returns the rules of a profile, the top level
+// rules for the empty name.
+func (cfg *Config) rules(profile string) []*Rule {
+	if profile == "" {
+		return cfg.Rules
+	}
+	return cfg.Profiles[profile]
+}
+
+// profiles iterates over the rules of each profile, starting
+// with the top level rules under the empty name.
+func (cfg *Config) profiles(yield func(string, []*Rule) bool) {
+	if !yield("", cfg.Rules) {
+		return
+	}
+	for name, rules := range iterutil.SortedMapIter(cfg.Profiles) {
+		if !yield(name, rules) {
+			return
+		}
+	}
 }
 
 type Rule struct {
-	Hosts   []string `json:"hosts"`
-	Proxies []string `json:"proxies"`
+	Hosts     []string            `json:"hosts"`
+	HostLists []*HostList         `json:"host_lists"` // more hosts, read from files or URLs
+	Ports     []netutil.PortRange `json:"ports"`
+	Networks  []netutil.Network   `json:"networks"`
+	Action    Action              `json:"action"`
+	Proxies   []string            `json:"proxies"`
+	Strategy  Strategy            `json:"strategy"` // how to pick from the proxies
+}
+
+// Strategy is how a rule picks the proxy for a connection.
+type Strategy string
+
+const (
+	StrategySequential   Strategy = "sequential" // in order, the next one if a dial fails
+	StrategyRace         Strategy = "race"       // staggered parallel dials, the first to connect wins
+	StrategyRoundRobin   Strategy = "round-robin"
+	StrategyLeastLatency Strategy = "least-latency"
+)
+
+var _ encoding.TextUnmarshaler = (*Strategy)(nil)
+
+func (s *Strategy) UnmarshalText(text []byte) error {
+	switch st := Strategy(text); st {
+	case "", StrategySequential, StrategyRace, StrategyRoundRobin, StrategyLeastLatency:
+		*s = st
+		return nil
+	}
+	return fmt.Errorf("invalid strategy %q, expected sequential, race, round-robin or least-latency", text)
+}
+
+// Action is what a rule does with the connections it matches.
+type Action string
+
+const (
+	ActionProxy  Action = "proxy" // connect through the proxies, direct if there are none
+	ActionDirect Action = "direct"
+	ActionReject Action = "reject"
+	ActionDrop   Action = "drop"
+)
+
+var _ encoding.TextUnmarshaler = (*Action)(nil)
+
+func (a *Action) UnmarshalText(text []byte) error {
+	switch act := Action(text); act {
+	case "", ActionProxy, ActionDirect, ActionReject, ActionDrop:
+		*a = act
+		return nil
+	}
+	return fmt.Errorf("invalid action %q, expected proxy, direct, reject or drop", text)
+}
+
+// check reports a rule that lists proxies but does not use them.
+func (r *Rule) check() error {
+	if r.Action != "" && r.Action != ActionProxy && len(r.Proxies) > 0 {
+		return fmt.Errorf("rule with action %s cannot have proxies", r.Action)
+	}
+	return nil
+}
+
+// Filter returns the ports and networks the rule is restricted to.
+func (r *Rule) Filter() dialer.Filter {
+	return dialer.Filter{Ports: r.Ports, Networks: r.Networks}
+}
+
+// passwordFields are the ways a password is given in the config.
+type passwordFields struct {
+	Password        string            `json:"password"`
+	PasswordCommand string            `json:"password_command"`
+	PasswordFile    string            `json:"password_file"`
+	PasswordEnv     string            `json:"password_env"`
+	PasswordSecret  map[string]string `json:"password_secret"`
+}
+
+// source returns where the password is looked up,
+// nil if it is given in plain text or not at all.
+func (p *passwordFields) source() (secret.Source, error) {
+	var sources []secret.Source
+	if p.PasswordCommand != "" {
+		sources = append(sources, secret.Command(p.PasswordCommand))
+	}
+	if p.PasswordFile != "" {
+		sources = append(sources, secret.File(p.PasswordFile))
+	}
+	if p.PasswordEnv != "" {
+		sources = append(sources, secret.Env(p.PasswordEnv))
+	}
+	if len(p.PasswordSecret) > 0 {
+		sources = append(sources, secret.Service(p.PasswordSecret))
+	}
+	switch {
+	case len(sources) > 1 || len(sources) == 1 && p.Password != "":
+		return nil, errors.New("only one of password, password_command, password_file, password_env and password_secret may be set")
+	case len(sources) == 1:
+		return sources[0], nil
+	}
+	return nil, nil
 }
 
 type URL struct {
 	url.URL
+	// Password is looked up when the dialer is built,
+	// instead of a password stored in the URL.
+	Password secret.Source
+	// HealthCheck probes the proxy while it is connected. Changing it
+	// does not make a different proxy, so Equal ignores it.
+	HealthCheck *HealthCheck
+	// Reconnect retries a failed proxy automatically, nil leaves
+	// it failed until reset. Equal ignores it too.
+	Reconnect *Reconnect
+}
+
+// Equal reports whether both URLs describe the same proxy.
+func (u *URL) Equal(o *URL) bool {
+	return u.String() == o.String() && fmt.Sprint(u.Password) == fmt.Sprint(o.Password)
+}
+
+// Resolve returns the URL with the password looked up from its secret source.
+func (u *URL) Resolve(ctx context.Context) (*url.URL, error) {
+	if u.Password == nil {
+		return &u.URL, nil
+	}
+	password, err := u.Password.Secret(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve password from %s: %w", u.Password, err)
+	}
+	r := u.URL
+	r.User = url.UserPassword(u.User.Username(), password)
+	return &r, nil
 }
 
 var _ json.Unmarshaler = (*URL)(nil)
@@ -46,12 +215,14 @@ func (u *URL) UnmarshalJSON(data []byte) error {
 	}
 
 	type Parts struct {
-		Username string            `json:"username"`
-		Password string            `json:"password"`
-		Protocol string            `json:"protocol"`
-		Host     string            `json:"host"`
-		Path     string            `json:"path"`
-		Options  map[string]string `json:"options"`
+		Username string `json:"username"`
+		passwordFields
+		Protocol    string            `json:"protocol"`
+		Host        string            `json:"host"`
+		Path        string            `json:"path"`
+		Options     map[string]string `json:"options"`
+		HealthCheck *HealthCheck      `json:"health_check"`
+		Reconnect   *Reconnect        `json:"reconnect"`
 	}
 
 	var p Parts
@@ -62,9 +233,30 @@ func (u *URL) UnmarshalJSON(data []byte) error {
 	u.Scheme = p.Protocol
 	u.Host = p.Host
 	u.Path = path.Clean("/" + p.Path)
+	var err error
+	if u.Password, err = p.source(); err != nil {
+		return err
+	}
+
 	if p.Username != "" || p.Password != "" {
 		u.User = url.UserPassword(p.Username, p.Password)
 	}
+	if u.Password != nil && u.User == nil {
+		u.User = url.User(p.Username)
+	}
+	if p.HealthCheck != nil {
+		if err := p.HealthCheck.check(); err != nil {
+			return err
+		}
+		if p.HealthCheck.OnFailure == HealthReconnect && interactive(p.Protocol) {
+			return fmt.Errorf("on_failure reconnect is not supported for %s proxies, they may prompt for credentials", p.Protocol)
+		}
+		u.HealthCheck = p.HealthCheck
+	}
+	if p.Reconnect != nil && interactive(p.Protocol) {
+		return fmt.Errorf("reconnect is not supported for %s proxies, they may prompt for credentials", p.Protocol)
+	}
+	u.Reconnect = p.Reconnect
 	if len(p.Options) > 0 {
 		q := url.Values{}
 		for k, v := range p.Options {
@@ -76,6 +268,24 @@ func (u *URL) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+// Duration is a time.Duration written like "1h30m".
+type Duration time.Duration
+
+var _ encoding.TextUnmarshaler = (*Duration)(nil)
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
 // File wraps os.File and implements flag.Value.
 type Path string
 
@@ -121,28 +331,193 @@ func EnsureDefaultConfigFile() (Path, error) {
 	return DefaultConfigPath, nil
 }
 
+// ConfigDir is the directory next to the main config file
+// whose *.yaml files are merged into the config automatically.
+const ConfigDir = "config.d"
+
+// Files returns the paths of the files the config was read from,
+// the directories that may gain files matching an include,
+// and the files of host lists.
+func (cfg *Config) Files() ([]string, error) {
+	if len(cfg.parts) == 0 {
+		s, err := cfg.Path.ExpandUser()
+		if err != nil {
+			return nil, err
+		}
+		return []string{s}, nil
+	}
+	files := make([]string, 0, len(cfg.parts)+len(cfg.dirs))
+	for _, part := range cfg.parts {
+		s, err := part.Path.ExpandUser()
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, s)
+	}
+	files = append(files, cfg.dirs...)
+	for _, rules := range cfg.profiles {
+		for _, r := range rules {
+			for _, hl := range r.HostLists {
+				if hl.IsURL() {
+					continue
+				}
+				s, err := Path(hl.Source).ExpandUser()
+				if err != nil {
+					return nil, err
+				}
+				files = append(files, s)
+			}
+		}
+	}
+	return files, nil
+}
+
+// ParseConfigFile reads the config at path together with the files it
+// includes and the files in the config.d directory next to it.
+//
+// The files are merged in order: the main file, its includes depth first,
+// then config.d in lexical order. Proxies merge by label and rules are
+// appended. A label defined by more than one file is an error.
 func ParseConfigFile(path Path) (*Config, error) {
 	s, err := path.ExpandUser()
 	if err != nil {
 		return nil, err
 	}
 
-	f, err := os.Open(s)
+	m := merger{
+		seen:     make(map[string]bool),
+		labels:   make(map[string]string),
+		profiles: make(map[string]string),
+	}
+	root, err := m.load(s)
 	if err != nil {
 		return nil, err
 	}
-	defer f.Close()
+	root.Path = path
 
-	data, err := io.ReadAll(f)
+	dir := filepath.Join(filepath.Dir(s), ConfigDir)
+	err = m.glob(filepath.Join(dir, "*.yaml"))
 	if err != nil {
 		return nil, err
 	}
 
-	var rs Config
-	err = yaml.Unmarshal(data, &rs)
+	rs := Config{
+		Path:      path,
+		source:    root.source,
+		Listen:    root.Listen,
+		Listeners: root.Listeners,
+		Control:   root.Control,
+		AccessLog: root.AccessLog,
+		Auth:      root.Auth,
+		Include:   root.Include,
+		Proxies:   make(map[string]*URL),
+		Profiles:  make(map[string][]*Rule),
+		parts:     m.parts,
+		dirs:      m.dirs,
+	}
+	for _, part := range m.parts {
+		maps.Copy(rs.Proxies, part.Proxies)
+		maps.Copy(rs.Profiles, part.Profiles)
+		rs.Rules = append(rs.Rules, part.Rules...)
+	}
+	for _, l := range rs.Listeners {
+		if _, ok := rs.Profiles[l.Profile]; l.Profile != "" && !ok {
+			return nil, fmt.Errorf("%s: listener %s: profile %q is not defined", s, l, l.Profile)
+		}
+		if l.Auth && rs.Auth == nil {
+			return nil, fmt.Errorf("%s: listener %s: auth is enabled but not configured", s, l)
+		}
+	}
+	return &rs, nil
+}
+
+// merger collects the files of a config in merge order.
+type merger struct {
+	parts    []*Config
+	dirs     []string
+	seen     map[string]bool   // files already loaded
+	labels   map[string]string // proxy label to the file defining it
+	profiles map[string]string // profile name to the file defining it
+}
+
+// load parses the file at name, followed by the files it includes.
+func (m *merger) load(name string) (*Config, error) {
+	m.seen[name] = true
+
+	data, err := os.ReadFile(name)
 	if err != nil {
 		return nil, err
 	}
-	rs.Path = path
-	return &rs, nil
+
+	var part Config
+	err = yaml.Unmarshal(data, &part)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	part.Path = Path(name)
+	part.source = data
+
+	if len(m.parts) > 0 && (part.Listen != "" || len(part.Listeners) > 0 || part.Control != "" || part.AccessLog != nil || part.Auth != nil) {
+		return nil, fmt.Errorf("%s: listen, listeners, control_socket, access_log and auth may only be set in the main config", name)
+	}
+	for label := range part.Proxies {
+		if prev, ok := m.labels[label]; ok {
+			return nil, fmt.Errorf("%s: proxy %q is already defined in %s", name, label, prev)
+		}
+		m.labels[label] = name
+	}
+	for profile := range part.Profiles {
+		if prev, ok := m.profiles[profile]; ok {
+			return nil, fmt.Errorf("%s: profile %q is already defined in %s", name, profile, prev)
+		}
+		m.profiles[profile] = name
+	}
+	m.parts = append(m.parts, &part)
+
+	for _, pattern := range part.Include {
+		p, err := Path(pattern).ExpandUser()
+		if err != nil {
+			return nil, err
+		}
+		if !filepath.IsAbs(p) {
+			p = filepath.Join(filepath.Dir(name), p)
+		}
+		err = m.glob(p)
+		if err != nil {
+			return nil, fmt.Errorf("%s: include %q: %w", name, pattern, err)
+		}
+	}
+	return &part, nil
+}
+
+// glob loads the files matching pattern that were not loaded before.
+// A pattern without magic characters must name an existing file.
+func (m *merger) glob(pattern string) error {
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return err
+	}
+	if !hasMeta(pattern) {
+		if len(matches) == 0 {
+			return fmt.Errorf("%w: %s", fs.ErrNotExist, pattern)
+		}
+	} else {
+		m.dirs = append(m.dirs, filepath.Dir(pattern))
+	}
+	for _, name := range matches {
+		if m.seen[name] {
+			continue
+		}
+		if fi, err := os.Stat(name); err != nil || fi.IsDir() {
+			continue
+		}
+		if _, err := m.load(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func hasMeta(pattern string) bool {
+	return strings.ContainsAny(pattern, "*?[")
 }
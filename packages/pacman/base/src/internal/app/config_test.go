@@ -0,0 +1,161 @@
+package app
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"sigs.k8s.io/yaml"
+)
+
+// writeFiles writes the files, named by slash-separated paths, to a
+// temporary directory and returns the path of its config.yaml.
+func writeFiles(t *testing.T, files map[string]string) Path {
+	t.Helper()
+	dir := t.TempDir()
+	for name, src := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(src), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return Path(filepath.Join(dir, "config.yaml"))
+}
+
+// proxyRule is a config with a proxy and a rule using it.
+func proxyRule(label string) string {
+	return `proxies:
+  ` + label + `:
+    protocol: socks5
+    host: 127.0.0.1:1080
+rules:
+  - hosts: [` + label + `.test]
+    proxies: [` + label + `]
+`
+}
+
+func TestParseConfigFileMerge(t *testing.T) {
+	path := writeFiles(t, map[string]string{
+		"config.yaml":     "include: [teams/*.yaml]\n" + proxyRule("main"),
+		"teams/b.yaml":    proxyRule("team-b"),
+		"teams/a.yaml":    "include: [../shared.yaml]\n" + proxyRule("team-a"),
+		"shared.yaml":     proxyRule("shared"),
+		"config.d/z.yaml": proxyRule("z"),
+		"config.d/a.yaml": proxyRule("a"),
+	})
+	cfg, err := ParseConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the main file, its includes depth first, then config.d
+	want := []string{"main", "team-a", "shared", "team-b", "a", "z"}
+	var got []string
+	for _, r := range cfg.Rules {
+		got = append(got, r.Proxies...)
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("got rules for %q, want %q", got, want)
+	}
+	slices.Sort(want)
+	if labels := slices.Sorted(maps.Keys(cfg.Proxies)); !slices.Equal(labels, want) {
+		t.Errorf("got proxies %q, want %q", labels, want)
+	}
+}
+
+func TestParseConfigFileMergeErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		files  map[string]string
+		expect string
+	}{
+		{
+			name: "proxy in an include",
+			files: map[string]string{
+				"config.yaml": "include: [other.yaml]\n" + proxyRule("vpn"),
+				"other.yaml":  proxyRule("vpn"),
+			},
+			expect: `other.yaml: proxy "vpn" is already defined in `,
+		},
+		{
+			name: "proxy in config.d",
+			files: map[string]string{
+				"config.yaml":     proxyRule("vpn"),
+				"config.d/a.yaml": proxyRule("vpn"),
+			},
+			expect: `a.yaml: proxy "vpn" is already defined in `,
+		},
+		{
+			name: "profile",
+			files: map[string]string{
+				"config.yaml":     "profiles:\n  work: []\n",
+				"config.d/a.yaml": "profiles:\n  work: []\n",
+			},
+			expect: `a.yaml: profile "work" is already defined in `,
+		},
+		{
+			name: "listeners outside the main file",
+			files: map[string]string{
+				"config.yaml":     proxyRule("vpn"),
+				"config.d/a.yaml": "listen: 127.0.0.1:8080\n",
+			},
+			expect: "may only be set in the main config",
+		},
+		{
+			name: "missing include",
+			files: map[string]string{
+				"config.yaml": "include: [missing.yaml]\n",
+			},
+			expect: `include "missing.yaml"`,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := ParseConfigFile(writeFiles(t, tc.files))
+			if err == nil || !strings.Contains(err.Error(), tc.expect) {
+				t.Errorf("got %v, want an error containing %q", err, tc.expect)
+			}
+		})
+	}
+}
+
+func TestURLReconnectInteractive(t *testing.T) {
+	tests := []struct {
+		name   string
+		src    string
+		expect string
+	}{
+		{
+			name:   "reconnect",
+			src:    "reconnect: {attempts: 3}",
+			expect: "reconnect is not supported for anyconnect proxies",
+		},
+		{
+			name:   "health check",
+			src:    "health_check: {target: intranet.test:443, on_failure: reconnect}",
+			expect: "on_failure reconnect is not supported for anyconnect proxies",
+		},
+		{
+			name: "health check failing",
+			src:  "health_check: {target: intranet.test:443, on_failure: fail}",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var u URL
+			err := yaml.Unmarshal([]byte("protocol: anyconnect\nhost: vpn.test\n"+tc.src), &u)
+			switch {
+			case tc.expect == "" && err != nil:
+				t.Errorf("got %v, want no error", err)
+			case tc.expect != "" && (err == nil || !strings.Contains(err.Error(), tc.expect)):
+				t.Errorf("got %v, want an error containing %q", err, tc.expect)
+			}
+		})
+	}
+}
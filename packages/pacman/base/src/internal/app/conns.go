@@ -0,0 +1,283 @@
+package app
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/proxy"
+
+	"github.com/gilliginsisland/pacman/pkg/netutil"
+)
+
+// ConnInfo describes an open connection dialed for a client.
+type ConnInfo struct {
+	ID          uint64    `json:"id"`
+	Protocol    Protocol  `json:"protocol"` // of the listener that accepted the client
+	Client      string    `json:"client,omitempty"`
+	Network     string    `json:"network"`
+	Destination string    `json:"destination"`
+	Rule        string    `json:"rule,omitempty"`  // number of the matching rule, or profile/number
+	Proxy       string    `json:"proxy,omitempty"` // empty if connected directly
+	Chain       []string  `json:"chain,omitempty"` // the proxy, then the proxies it was reached through
+	Start       time.Time `json:"start"`
+	Sent        int64     `json:"sent"`     // bytes written to the destination
+	Received    int64     `json:"received"` // bytes read from the destination
+}
+
+// ConnTable tracks the connections dialed by the listeners
+// until they are closed.
+type ConnTable struct {
+	// Log, if set, gets an AccessEntry for every connection when it
+	// is closed, and for every dial that failed.
+	Log io.Writer
+
+	mu    sync.Mutex
+	last  uint64
+	conns map[uint64]*trackedConn
+}
+
+// errClosed is the error logged for connections closed through the API.
+var errClosed = errors.New("closed through the control API")
+
+// Dialer returns a dial function that records the connections of d,
+// accepted by a listener of the protocol.
+func (t *ConnTable) Dialer(d proxy.ContextDialer, protocol Protocol) func(ctx context.Context, network, address string) (net.Conn, error) {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		var route connRoute
+		start := time.Now()
+		ctx = context.WithValue(ctx, connRouteKey{}, &route)
+		ctx = context.WithValue(ctx, hopKey{}, &route.hop)
+		conn, err := d.DialContext(ctx, network, address)
+
+		info := ConnInfo{
+			Protocol:    protocol,
+			Client:      netutil.Client(ctx),
+			Network:     network,
+			Destination: address,
+			Rule:        route.rule,
+			Chain:       route.chain(),
+			Start:       start,
+		}
+		if len(info.Chain) > 0 {
+			info.Proxy = info.Chain[0]
+		}
+		if err != nil {
+			if t.Log != nil {
+				writeAccess(t.Log, newAccessEntry(info, time.Since(start), err))
+			}
+			return nil, err
+		}
+		tc := &trackedConn{
+			Conn:  conn,
+			table: t,
+			info:  info,
+			dial:  time.Since(start),
+		}
+		t.add(tc)
+		return tc, nil
+	}
+}
+
+func (t *ConnTable) add(c *trackedConn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.conns == nil {
+		t.conns = make(map[uint64]*trackedConn)
+	}
+	t.last++
+	c.info.ID = t.last
+	t.conns[c.info.ID] = c
+}
+
+func (t *ConnTable) remove(c *trackedConn) {
+	t.mu.Lock()
+	delete(t.conns, c.info.ID)
+	t.mu.Unlock()
+	if t.Log != nil {
+		writeAccess(t.Log, newAccessEntry(c.Info(), c.dial, c.err()))
+	}
+}
+
+// find returns the connections accepted by match, ordered by ID.
+func (t *ConnTable) find(match func(*ConnInfo) bool) []*trackedConn {
+	t.mu.Lock()
+	conns := make([]*trackedConn, 0, len(t.conns))
+	for _, c := range t.conns {
+		if match(&c.info) {
+			conns = append(conns, c)
+		}
+	}
+	t.mu.Unlock()
+	slices.SortFunc(conns, func(a, b *trackedConn) int {
+		return cmp.Compare(a.info.ID, b.info.ID)
+	})
+	return conns
+}
+
+// through returns a match for the connections through the proxy,
+// or all of them if proxy is empty.
+func through(proxy string) func(*ConnInfo) bool {
+	return func(c *ConnInfo) bool {
+		return proxy == "" || slices.Contains(c.Chain, proxy)
+	}
+}
+
+// List returns the open connections through the proxy,
+// or all of them if proxy is empty.
+func (t *ConnTable) List(proxy string) []ConnInfo {
+	conns := t.find(through(proxy))
+	infos := make([]ConnInfo, len(conns))
+	for i, c := range conns {
+		infos[i] = c.Info()
+	}
+	return infos
+}
+
+// Close closes the connection with the given ID.
+// It reports false if there is none.
+func (t *ConnTable) Close(id uint64) (ConnInfo, bool) {
+	conns := t.find(func(c *ConnInfo) bool { return c.ID == id })
+	if len(conns) == 0 {
+		return ConnInfo{}, false
+	}
+	conns[0].closeWith(errClosed)
+	return conns[0].Info(), true
+}
+
+// CloseProxy closes the connections through the proxy, or all of them
+// if proxy is empty, and returns them. reason is logged as their error.
+func (t *ConnTable) CloseProxy(proxy string, reason error) []ConnInfo {
+	conns := t.find(through(proxy))
+	infos := make([]ConnInfo, len(conns))
+	for i, c := range conns {
+		c.closeWith(reason)
+		infos[i] = c.Info()
+	}
+	return infos
+}
+
+type connRouteKey struct{}
+
+// connRoute collects how a tracked connection is routed while it is dialed.
+type connRoute struct {
+	once sync.Once
+	rule string
+	hop  hop // next is the proxy picked by the rule
+}
+
+// setRule records the rule of the connection. Proxies dial their own
+// hosts through the rules too, so only the first rule is the one that
+// matched the destination.
+func (r *connRoute) setRule(rule string) {
+	r.once.Do(func() { r.rule = rule })
+}
+
+// chain returns the labels of the proxies the connection went through,
+// or of those the last attempt went through if the dial failed.
+func (r *connRoute) chain() []string {
+	var labels []string
+	for h := r.hop.via(); h != nil; h = h.via() {
+		labels = append(labels, h.label)
+	}
+	return labels
+}
+
+type hopKey struct{}
+
+// hop is a proxy dialed for a tracked connection.
+type hop struct {
+	label string
+	next  atomic.Pointer[hop] // the first proxy dialed through it that connected
+	tried atomic.Pointer[hop] // the last one that failed
+}
+
+// dialed records the hop as one its parent went through.
+// Only the first successful dial counts, the losers of a race
+// are closed.
+func (h *hop) dialed(parent *hop, err error) {
+	if err != nil {
+		parent.tried.Store(h)
+		return
+	}
+	parent.next.CompareAndSwap(nil, h)
+}
+
+func (h *hop) via() *hop {
+	if next := h.next.Load(); next != nil {
+		return next
+	}
+	return h.tried.Load()
+}
+
+// trackedConn is a connection in the table until it is closed.
+type trackedConn struct {
+	net.Conn
+	table *ConnTable
+	info  ConnInfo      // fixed once the connection is added
+	dial  time.Duration // time taken to connect
+
+	sent, received atomic.Int64
+	failure        atomic.Pointer[error] // first error, or why it was closed
+	once           sync.Once
+}
+
+// Info returns the description of the connection with its byte counts.
+func (c *trackedConn) Info() ConnInfo {
+	info := c.info
+	info.Sent, info.Received = c.sent.Load(), c.received.Load()
+	return info
+}
+
+func (c *trackedConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.received.Add(int64(n))
+	if err != nil && err != io.EOF {
+		c.fail(err)
+	}
+	return n, err
+}
+
+func (c *trackedConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.sent.Add(int64(n))
+	if err != nil {
+		c.fail(err)
+	}
+	return n, err
+}
+
+// fail records the first error of the connection. Errors caused by
+// closing it are not failures.
+func (c *trackedConn) fail(err error) {
+	if !errors.Is(err, net.ErrClosed) {
+		c.failure.CompareAndSwap(nil, &err)
+	}
+}
+
+func (c *trackedConn) err() error {
+	if err := c.failure.Load(); err != nil {
+		return *err
+	}
+	return nil
+}
+
+func (c *trackedConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(func() {
+		c.table.remove(c)
+	})
+	return err
+}
+
+// closeWith closes the connection, logging reason as its error.
+func (c *trackedConn) closeWith(reason error) {
+	c.failure.CompareAndSwap(nil, &reason)
+	c.Close()
+}
@@ -0,0 +1,111 @@
+package app
+
+import (
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/gilliginsisland/pacman/pkg/iterutil"
+)
+
+// ConfigDiff describes the changes between two configs.
+type ConfigDiff struct {
+	ProxiesAdded   []string
+	ProxiesRemoved []string
+	ProxiesChanged []string // proxies whose URL changed
+	RulesAdded     []string // including rules of profiles, as "profile: rule"
+	RulesRemoved   []string
+}
+
+// DiffConfig compares two configs. A nil old config diffs as empty.
+func DiffConfig(old, new *Config) ConfigDiff {
+	var d ConfigDiff
+	if old == nil {
+		old = &Config{}
+	}
+
+	for k, u := range iterutil.SortedMapIter(new.Proxies) {
+		if ou, ok := old.Proxies[k]; !ok {
+			d.ProxiesAdded = append(d.ProxiesAdded, k)
+		} else if !ou.Equal(u) {
+			d.ProxiesChanged = append(d.ProxiesChanged, k)
+		}
+	}
+	for k := range iterutil.SortedMapIter(old.Proxies) {
+		if _, ok := new.Proxies[k]; !ok {
+			d.ProxiesRemoved = append(d.ProxiesRemoved, k)
+		}
+	}
+
+	oldRules := ruleStrings(old.Rules)
+	newRules := ruleStrings(new.Rules)
+	// rules of profiles are prefixed with the name of their profile
+	for name, rules := range iterutil.SortedMapIter(old.Profiles) {
+		for _, r := range ruleStrings(rules) {
+			oldRules = append(oldRules, name+": "+r)
+		}
+	}
+	for name, rules := range iterutil.SortedMapIter(new.Profiles) {
+		for _, r := range ruleStrings(rules) {
+			newRules = append(newRules, name+": "+r)
+		}
+	}
+	for _, r := range newRules {
+		if !slices.Contains(oldRules, r) {
+			d.RulesAdded = append(d.RulesAdded, r)
+		}
+	}
+	for _, r := range oldRules {
+		if !slices.Contains(newRules, r) {
+			d.RulesRemoved = append(d.RulesRemoved, r)
+		}
+	}
+
+	return d
+}
+
+// Empty reports whether the configs were equivalent.
+func (d ConfigDiff) Empty() bool {
+	return len(d.ProxiesAdded)+len(d.ProxiesRemoved)+len(d.ProxiesChanged)+
+		len(d.RulesAdded)+len(d.RulesRemoved) == 0
+}
+
+func (d ConfigDiff) LogValue() slog.Value {
+	var attrs []slog.Attr
+	add := func(key string, v []string) {
+		if len(v) > 0 {
+			attrs = append(attrs, slog.Any(key, v))
+		}
+	}
+	add("proxies_added", d.ProxiesAdded)
+	add("proxies_removed", d.ProxiesRemoved)
+	add("proxies_changed", d.ProxiesChanged)
+	add("rules_added", d.RulesAdded)
+	add("rules_removed", d.RulesRemoved)
+	return slog.GroupValue(attrs...)
+}
+
+// ruleStrings renders each rule as "host, ... [filter] -> proxy, ... (strategy)"
+// or "host, ... [filter] -> action".
+func ruleStrings(rules []*Rule) []string {
+	s := make([]string, len(rules))
+	for i, r := range rules {
+		hosts := slices.Clone(r.Hosts)
+		for _, hl := range r.HostLists {
+			hosts = append(hosts, hl.Source)
+		}
+		s[i] = strings.Join(hosts, ", ")
+		if f := r.Filter(); !f.Empty() {
+			s[i] += " [" + f.String() + "]"
+		}
+		if r.Action != "" && r.Action != ActionProxy {
+			s[i] += " -> " + string(r.Action)
+		} else {
+			s[i] += " -> " + strings.Join(r.Proxies, ", ")
+			if r.Strategy != "" && r.Strategy != StrategySequential {
+				s[i] += " (" + string(r.Strategy) + ")"
+			}
+		}
+	}
+	return s
+}
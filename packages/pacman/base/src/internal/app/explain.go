@@ -0,0 +1,116 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gilliginsisland/pacman/pkg/dialer"
+)
+
+// Explanation is how a connection to a host or URL would be routed.
+type Explanation struct {
+	Input string `json:"input"`
+	*RouteInfo
+	Error string `json:"error,omitempty"`
+}
+
+// Explainer routes connections with the rules of a config without
+// running it. Proxies are never connected, and host lists from URLs
+// are read from their cached copy.
+type Explainer struct {
+	pacman PACMan
+}
+
+// NewExplainer applies the rules of the config.
+func NewExplainer(cfg *Config) (*Explainer, error) {
+	if err := cfg.check(); err != nil {
+		return nil, err
+	}
+	e := &Explainer{pacman: PACMan{
+		cfg:      cfg,
+		dialer:   dialer.ByHost{Default: directDialer},
+		profiles: make(map[string]*dialer.ByHost),
+		pool:     make(DialerPool),
+		lists:    make(map[string]*runningList),
+	}}
+	for k, u := range cfg.Proxies {
+		e.pacman.pool[k] = NewPooledDialer(k, u, &e.pacman.dialer)
+	}
+	for _, rules := range cfg.profiles {
+		for _, r := range rules {
+			for _, hl := range r.HostLists {
+				if _, ok := e.pacman.lists[hl.key()]; !ok {
+					rl := &runningList{list: hl}
+					rl.load()
+					e.pacman.lists[hl.key()] = rl
+				}
+			}
+		}
+	}
+	e.pacman.mu.Lock()
+	e.pacman.applyRules()
+	e.pacman.mu.Unlock()
+	return e, nil
+}
+
+// Explain routes a connection to target, given as host, host:port or
+// a URL, from a listener of the profile.
+func (e *Explainer) Explain(ctx context.Context, profile, network, target string) Explanation {
+	ex := Explanation{Input: target}
+	host, port, err := parseTarget(target)
+	if err == nil {
+		ex.RouteInfo, err = e.pacman.Route(ctx, profile, network, host, port)
+	}
+	if err != nil {
+		ex.Error = err.Error()
+	}
+	return ex
+}
+
+// parseTarget returns the host and port of host, host:port or a URL.
+// The port of a URL defaults to the one of its scheme, and is 0 when
+// not given otherwise.
+func parseTarget(target string) (string, int, error) {
+	target = strings.TrimSpace(target)
+	if strings.Contains(target, "://") {
+		u, err := url.Parse(target)
+		if err != nil {
+			return "", 0, err
+		}
+		if u.Hostname() == "" {
+			return "", 0, fmt.Errorf("no host in %q", target)
+		}
+		port := u.Port()
+		if port == "" {
+			port = schemePorts[u.Scheme]
+		}
+		p, _ := strconv.Atoi(port)
+		return u.Hostname(), p, nil
+	}
+	if host, port, err := net.SplitHostPort(target); err == nil {
+		p, err := strconv.Atoi(port)
+		if err != nil || p < 0 || p > 65535 {
+			return "", 0, fmt.Errorf("invalid port %q", port)
+		}
+		return host, p, nil
+	}
+	if target == "" {
+		return "", 0, errors.New("empty host")
+	}
+	// a bare IPv6 address, possibly in brackets
+	return strings.TrimSuffix(strings.TrimPrefix(target, "["), "]"), 0, nil
+}
+
+var schemePorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+	"ws":    "80",
+	"wss":   "443",
+	"ssh":   "22",
+	"ftp":   "21",
+}
@@ -0,0 +1,199 @@
+package app
+
+import (
+	"context"
+	"encoding"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gilliginsisland/pacman/pkg/dialer"
+)
+
+const (
+	// DefaultHealthInterval is how often a proxy is probed.
+	DefaultHealthInterval = 30 * time.Second
+	// DefaultHealthTimeout is how long a probe may take.
+	DefaultHealthTimeout = 10 * time.Second
+	// DefaultHealthFailures is how many probes in a row must fail
+	// before the proxy is considered dead.
+	DefaultHealthFailures = 3
+)
+
+// HealthCheck probes a proxy while it is connected, to notice
+// a dead session before a user's connection does.
+type HealthCheck struct {
+	Target    string          `json:"target"`   // host:port to connect to, or an http(s) URL to fetch
+	Interval  Duration        `json:"interval"` // DefaultHealthInterval if zero
+	Timeout   Duration        `json:"timeout"`  // DefaultHealthTimeout if zero
+	Failures  int             `json:"failures"` // DefaultHealthFailures if zero
+	OnFailure HealthOnFailure `json:"on_failure"`
+}
+
+// HealthOnFailure is what happens to a proxy that fails its health check.
+type HealthOnFailure string
+
+const (
+	HealthFail      HealthOnFailure = "fail"      // disconnect and mark the proxy failed until reset
+	HealthReconnect HealthOnFailure = "reconnect" // disconnect and connect again right away
+)
+
+var _ encoding.TextUnmarshaler = (*HealthOnFailure)(nil)
+
+func (a *HealthOnFailure) UnmarshalText(text []byte) error {
+	switch act := HealthOnFailure(text); act {
+	case "", HealthFail, HealthReconnect:
+		*a = act
+		return nil
+	}
+	return fmt.Errorf("invalid on_failure %q, expected fail or reconnect", text)
+}
+
+// check reports a target that cannot be probed.
+func (hc *HealthCheck) check() error {
+	if hc.Target == "" {
+		return errors.New("health check without a target")
+	}
+	if hc.isURL() {
+		_, err := url.Parse(hc.Target)
+		return err
+	}
+	_, _, err := net.SplitHostPort(hc.Target)
+	return err
+}
+
+func (hc *HealthCheck) isURL() bool {
+	u, err := url.Parse(hc.Target)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https")
+}
+
+func (hc *HealthCheck) interval() time.Duration {
+	if hc.Interval <= 0 {
+		return DefaultHealthInterval
+	}
+	return time.Duration(hc.Interval)
+}
+
+func (hc *HealthCheck) timeout() time.Duration {
+	if hc.Timeout <= 0 {
+		return DefaultHealthTimeout
+	}
+	return time.Duration(hc.Timeout)
+}
+
+func (hc *HealthCheck) failures() int {
+	if hc.Failures <= 0 {
+		return DefaultHealthFailures
+	}
+	return hc.Failures
+}
+
+// probe connects to the target through the dialer, or fetches it if it is
+// a URL. Any HTTP response counts as healthy.
+func (hc *HealthCheck) probe(ctx context.Context, ld *dialer.Lazy) error {
+	ctx, cancel := context.WithTimeout(ctx, hc.timeout())
+	defer cancel()
+
+	if !hc.isURL() {
+		conn, err := ld.Probe(ctx, "tcp", hc.Target)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, hc.Target, nil)
+	if err != nil {
+		return err
+	}
+	client := http.Client{
+		Transport: &http.Transport{
+			DialContext:       ld.Probe,
+			DisableKeepAlives: true,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// SetHealthCheck starts probing the dialer with hc, replacing the previous
+// health check. A nil hc stops probing. Probe times are recorded in lat.
+func (pd *PooledDialer) SetHealthCheck(hc *HealthCheck, lat *dialer.Latencies) {
+	if pd.health == nil && hc == nil || pd.health != nil && hc != nil && *pd.health == *hc {
+		return
+	}
+	if pd.stopHealth != nil {
+		pd.stopHealth()
+	}
+	pd.health, pd.stopHealth = hc, nil
+	if hc == nil {
+		return
+	}
+	var ctx context.Context
+	ctx, pd.stopHealth = context.WithCancel(pd.ctx)
+	go pd.checkHealth(ctx, hc, lat)
+}
+
+// checkHealth probes the dialer while it is online. After enough failed
+// probes in a row the connection is torn down.
+func (pd *PooledDialer) checkHealth(ctx context.Context, hc *HealthCheck, lat *dialer.Latencies) {
+	log := slog.With(slog.String("proxy", pd.Label), slog.String("target", hc.Target))
+	failures := 0
+
+	ticker := time.NewTicker(hc.interval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if state, _ := pd.State(); state != dialer.Online {
+			failures = 0
+			continue
+		}
+
+		start := time.Now()
+		err := hc.probe(ctx, pd.dialer)
+		switch {
+		case ctx.Err() != nil:
+			return
+		case err == nil:
+			failures = 0
+			lat.Observe(pd.dial, time.Since(start))
+			continue
+		case errors.Is(err, dialer.ErrNotOnline):
+			// went offline between the state check and the probe
+			failures = 0
+			continue
+		}
+
+		failures++
+		lat.Fail(pd.dial)
+		log.Warn("health check failed", slog.Int("failures", failures), slog.Any("error", err))
+		if failures < hc.failures() {
+			continue
+		}
+
+		failures = 0
+		err = fmt.Errorf("%d health checks failed: %w", hc.failures(), err)
+		if hc.OnFailure == HealthReconnect {
+			log.Warn("proxy unhealthy, reconnecting")
+			pd.dialer.Reconnect(err)
+		} else {
+			log.Warn("proxy unhealthy, disconnecting")
+			pd.dialer.Fail(err)
+		}
+	}
+}
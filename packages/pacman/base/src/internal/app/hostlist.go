@@ -0,0 +1,256 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gilliginsisland/pacman/pkg/hostlist"
+)
+
+// DefaultRefresh is how often host lists from URLs are fetched.
+const DefaultRefresh = time.Hour
+
+// retryDelay is how long to wait before retrying a failed fetch.
+const retryDelay = time.Minute
+
+// HostList is a source of host patterns for a rule, a file or an HTTP URL.
+type HostList struct {
+	Source  string   `json:"source"`
+	Proxy   string   `json:"proxy"`   // proxy to fetch a URL through, the rules apply if empty
+	Refresh Duration `json:"refresh"` // how often to fetch a URL, DefaultRefresh if zero
+}
+
+var _ json.Unmarshaler = (*HostList)(nil)
+
+// UnmarshalJSON accepts the source alone as a shorthand.
+func (hl *HostList) UnmarshalJSON(data []byte) error {
+	var source string
+	if err := json.Unmarshal(data, &source); err == nil {
+		*hl = HostList{Source: source}
+		return nil
+	}
+	type plain HostList
+	if err := json.Unmarshal(data, (*plain)(hl)); err != nil {
+		return err
+	}
+	if hl.Source == "" {
+		return errors.New("host list without a source")
+	}
+	return nil
+}
+
+func (hl *HostList) String() string {
+	return hl.Source
+}
+
+// IsURL reports whether the list is fetched over HTTP.
+func (hl *HostList) IsURL() bool {
+	return strings.HasPrefix(hl.Source, "http://") || strings.HasPrefix(hl.Source, "https://")
+}
+
+func (hl *HostList) refresh() time.Duration {
+	if hl.Refresh <= 0 {
+		return DefaultRefresh
+	}
+	return time.Duration(hl.Refresh)
+}
+
+// key identifies lists that can share their entries.
+func (hl *HostList) key() string {
+	return fmt.Sprintf("%s|%s|%s", hl.Source, hl.Proxy, hl.refresh())
+}
+
+// fetcher returns the fetcher of a URL list.
+func (hl *HostList) fetcher(client *http.Client) (*hostlist.Fetcher, error) {
+	dir, err := HostListCacheDir()
+	if err != nil {
+		return nil, err
+	}
+	return &hostlist.Fetcher{URL: hl.Source, Client: client, Dir: dir}, nil
+}
+
+// Cached returns the entries of a file list, or the cached copy of a
+// URL list, without going to the network.
+func (hl *HostList) Cached() ([]string, error) {
+	if !hl.IsURL() {
+		p, err := Path(hl.Source).ExpandUser()
+		if err != nil {
+			return nil, err
+		}
+		return hostlist.ReadFile(p)
+	}
+	f, err := hl.fetcher(nil)
+	if err != nil {
+		return nil, err
+	}
+	return f.Cached()
+}
+
+// HostListCacheDir is where host lists fetched from URLs are cached.
+func HostListCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "pacman", "host_lists"), nil
+}
+
+// runningList keeps the last good entries of a host list.
+type runningList struct {
+	list   *HostList
+	hosts  atomic.Pointer[[]string]
+	cancel context.CancelFunc
+}
+
+func (rl *runningList) Hosts() []string {
+	if hosts := rl.hosts.Load(); hosts != nil {
+		return *hosts
+	}
+	return nil
+}
+
+// load reads the local copy of the list, keeping the entries
+// it has if that fails.
+func (rl *runningList) load() {
+	hosts, err := rl.list.Cached()
+	if err != nil {
+		if !errors.Is(err, hostlist.ErrNotCached) {
+			slog.Warn("failed to read host list, keeping last good copy",
+				slog.String("source", rl.list.Source),
+				slog.Any("error", err),
+			)
+		}
+		return
+	}
+	hosts = checkHosts(rl.list, hosts)
+	rl.hosts.Store(&hosts)
+}
+
+// checkHosts drops the entries of a host list that checkPattern
+// rejects, logging each of them.
+func checkHosts(hl *HostList, hosts []string) []string {
+	valid := make([]string, 0, len(hosts))
+	for _, host := range hosts {
+		if msg := checkPattern(host); msg != "" {
+			slog.Warn("skipping host list entry",
+				slog.String("source", hl.Source),
+				slog.String("error", fmt.Sprintf("host pattern %q %s", host, msg)),
+			)
+			continue
+		}
+		valid = append(valid, host)
+	}
+	return valid
+}
+
+// syncHostLists starts the host lists of the config and stops the ones it
+// no longer uses. Lists shared with the previous config keep their entries.
+// Must be called with the lock held.
+func (pacman *PACMan) syncHostLists(cfg *Config) {
+	lists := make(map[string]*runningList)
+	for _, rules := range cfg.profiles {
+		for _, r := range rules {
+			for _, hl := range r.HostLists {
+				key := hl.key()
+				if _, ok := lists[key]; ok {
+					continue
+				}
+				rl, ok := pacman.lists[key]
+				if !ok {
+					rl = &runningList{list: hl}
+					rl.load()
+					if hl.IsURL() {
+						var ctx context.Context
+						ctx, rl.cancel = context.WithCancel(context.Background())
+						go pacman.refreshHostList(ctx, rl)
+					}
+				} else if !hl.IsURL() {
+					// files are reread on every reload, the watcher
+					// reloads the config when they change
+					rl.load()
+				}
+				lists[key] = rl
+			}
+		}
+	}
+	for key, rl := range pacman.lists {
+		if _, ok := lists[key]; !ok && rl.cancel != nil {
+			rl.cancel()
+		}
+	}
+	pacman.lists = lists
+}
+
+// refreshHostList fetches a URL list periodically and applies the rules
+// again when it changes.
+func (pacman *PACMan) refreshHostList(ctx context.Context, rl *runningList) {
+	hl := rl.list
+	log := slog.With(slog.String("source", hl.Source))
+
+	for {
+		delay := hl.refresh()
+		hosts, changed, err := pacman.fetchHostList(ctx, hl)
+		if errors.Is(err, hostlist.ErrCacheWrite) {
+			// the fetched list is good, it just won't survive a restart
+			log.Warn("failed to cache host list", slog.Any("error", err))
+			err = nil
+		}
+		switch {
+		case ctx.Err() != nil:
+			return
+		case err != nil:
+			log.Warn("failed to fetch host list, keeping last good copy", slog.Any("error", err))
+			delay = min(delay, retryDelay)
+		case changed || rl.hosts.Load() == nil:
+			hosts = checkHosts(hl, hosts)
+			log.Info("host list updated", slog.Int("entries", len(hosts)))
+			rl.hosts.Store(&hosts)
+			pacman.mu.Lock()
+			pacman.applyRules()
+			pacman.mu.Unlock()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+// fetchHostList fetches a URL list through its proxy, or through the rules.
+func (pacman *PACMan) fetchHostList(ctx context.Context, hl *HostList) ([]string, bool, error) {
+	dial := pacman.dialer.DialContext
+	if hl.Proxy != "" {
+		pacman.mu.Lock()
+		pd, ok := pacman.pool[hl.Proxy]
+		pacman.mu.Unlock()
+		if !ok {
+			return nil, false, fmt.Errorf("%w: %s", ErrProxyNotFound, hl.Proxy)
+		}
+		dial = pd.dialer.DialContext
+	}
+
+	f, err := hl.fetcher(&http.Client{
+		Transport: &http.Transport{
+			DialContext:       dial,
+			DisableKeepAlives: true,
+		},
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+	return f.Fetch(ctx)
+}
@@ -0,0 +1,194 @@
+package app
+
+import (
+	"crypto/tls"
+	"encoding"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+
+	"github.com/gilliginsisland/pacman/pkg/localca"
+	"github.com/gilliginsisland/pacman/pkg/netutil"
+)
+
+// Listener is an address PACMan accepts connections on.
+type Listener struct {
+	Address   netutil.ListenAddr `json:"address"`
+	Protocols Protocols          `json:"protocols"`
+	Profile   string             `json:"profile"` // rule profile, the top level rules if empty
+	Auth      bool               `json:"auth"`    // require the credentials of the auth section
+	Allow     []netutil.Prefix   `json:"allow_clients"`
+	Deny      []netutil.Prefix   `json:"deny_clients"`
+	// ForwardedFor adds the client address to HTTP requests
+	// forwarded for absolute URIs.
+	ForwardedFor bool `json:"x_forwarded_for"`
+	// TLS terminates TLS on the listener, so that the
+	// protocols are served inside the encrypted connection.
+	TLS *ListenerTLS `json:"tls"`
+}
+
+func (l *Listener) String() string {
+	return l.Address.String()
+}
+
+// clients returns the filter of the clients of the listener. Unless
+// allow_clients is given, a listener bound to an address other than
+// loopback only allows loopback clients.
+func (l *Listener) clients() netutil.ClientFilter {
+	f := netutil.ClientFilter{Allow: l.Allow, Deny: l.Deny}
+	if f.Allow == nil && l.Address.Network == "tcp" && !isLoopback(l.Address.Address) {
+		f.Allow = netutil.Loopback
+	}
+	return f
+}
+
+// allowClient returns the check of the connections accepted by the
+// listener, which logs and counts the rejected ones.
+func (l *Listener) allowClient() func(net.Conn) bool {
+	f := l.clients()
+	name := l.String()
+	return func(conn net.Conn) bool {
+		if f.Allowed(conn.RemoteAddr()) {
+			return true
+		}
+		rejectedClients.With(name).Inc()
+		slog.Warn("client rejected", slog.String("listener", name), slog.String("client", conn.RemoteAddr().String()))
+		return false
+	}
+}
+
+// serves reports whether the listener serves the protocol. TCP listeners
+// only serve the control API if they list it, a web page that rebinds
+// its own name to their address could otherwise use it.
+func (l *Listener) serves(proto Protocol) bool {
+	if proto == ProtocolAPI && l.Address.Network == "tcp" {
+		return slices.Contains(l.Protocols, proto)
+	}
+	return l.Protocols.Has(proto)
+}
+
+// knownAs reports whether clients reach the listener by the host of a
+// request: a local name or address, any IP address, the host it listens
+// on or one of its TLS names. A page that rebinds its own name to the
+// address of the listener sends requests for that name instead.
+func (l *Listener) knownAs(host string) bool {
+	host = strings.TrimSuffix(host, ".")
+	if localca.IsLocal(host) {
+		return true
+	}
+	if _, err := netip.ParseAddr(host); err == nil {
+		return true
+	}
+	if h, _, err := net.SplitHostPort(l.Address.Address); err == nil && strings.EqualFold(h, host) {
+		return true
+	}
+	return l.TLS != nil && slices.ContainsFunc(l.TLS.Names, func(n string) bool {
+		return strings.EqualFold(n, host)
+	})
+}
+
+// isLoopback reports whether the host of address is a loopback address.
+func isLoopback(address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip, err := netip.ParseAddr(host)
+	return err == nil && ip.Unmap().IsLoopback()
+}
+
+// Listen opens the listener, wrapped in TLS if configured.
+// The handshake happens when the connection is first read.
+func (l *Listener) Listen() (net.Listener, error) {
+	var cfg *tls.Config
+	if l.TLS != nil {
+		// PAC files can only send clients to an HTTPS proxy
+		if l.Protocols.Has(ProtocolPAC) && !l.Protocols.Has(ProtocolHTTP) {
+			return nil, fmt.Errorf("listener %s: the PAC file of a tls listener needs the http protocol", l)
+		}
+		var err error
+		if cfg, err = l.TLS.config(); err != nil {
+			return nil, fmt.Errorf("listener %s: %w", l, err)
+		}
+	}
+	nl, err := l.listen()
+	if err != nil || cfg == nil {
+		return nl, err
+	}
+	return tls.NewListener(nl, cfg), nil
+}
+
+// listen opens the listener. The path of a Unix socket may start
+// with "~/", and a stale socket left behind by a previous run is replaced.
+func (l *Listener) listen() (net.Listener, error) {
+	if l.Address.Network != "unix" {
+		return net.Listen(l.Address.Network, l.Address.Address)
+	}
+
+	path, err := Path(l.Address.Address).ExpandUser()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	if fi, err := os.Lstat(path); err == nil && fi.Mode().Type() == fs.ModeSocket {
+		if c, err := net.Dial("unix", path); err == nil {
+			c.Close()
+			return nil, fmt.Errorf("listen unix %s: %w", path, syscall.EADDRINUSE)
+		}
+		os.Remove(path)
+	}
+	return net.Listen("unix", path)
+}
+
+// Protocol is a protocol served on a listener.
+type Protocol string
+
+const (
+	ProtocolSOCKS5  Protocol = "socks5"
+	ProtocolSOCKS4  Protocol = "socks4" // SOCKS4 and SOCKS4a
+	ProtocolSSH     Protocol = "ssh"
+	ProtocolHTTP    Protocol = "http"    // HTTP proxy, CONNECT and absolute URIs
+	ProtocolPAC     Protocol = "pac"     // the /proxy.pac file
+	ProtocolPprof   Protocol = "pprof"   // runtime diagnostics under /debug/pprof/
+	ProtocolAPI     Protocol = "api"     // the control API under /api/v1/
+	ProtocolMetrics Protocol = "metrics" // Prometheus metrics at /metrics
+)
+
+var protocols = []Protocol{ProtocolSOCKS5, ProtocolSOCKS4, ProtocolSSH, ProtocolHTTP, ProtocolPAC, ProtocolPprof, ProtocolAPI, ProtocolMetrics}
+
+var _ encoding.TextUnmarshaler = (*Protocol)(nil)
+
+func (p *Protocol) UnmarshalText(text []byte) error {
+	proto := Protocol(strings.ToLower(string(text)))
+	if !slices.Contains(protocols, proto) {
+		names := make([]string, len(protocols))
+		for i, p := range protocols {
+			names[i] = string(p)
+		}
+		return fmt.Errorf("invalid protocol %q, expected one of %s", text, strings.Join(names, ", "))
+	}
+	*p = proto
+	return nil
+}
+
+// Protocols is the set of protocols served on a listener.
+// An empty set serves all of them, except the control API on TCP
+// listeners, see Listener.serves.
+type Protocols []Protocol
+
+// Has reports whether the protocol is served.
+func (p Protocols) Has(proto Protocol) bool {
+	return len(p) == 0 || slices.Contains(p, proto)
+}
@@ -0,0 +1,103 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/gilliginsisland/pacman/pkg/dialer"
+	"github.com/gilliginsisland/pacman/pkg/iterutil"
+)
+
+// ProxyGraph maps each proxy label to the proxies used to reach its host.
+type ProxyGraph map[string][]string
+
+// NewProxyGraph resolves the host of every proxy against the rules of the
+// config. Only hosts that match statically are considered, loops that
+// appear after DNS resolution are caught at dial time.
+func NewProxyGraph(cfg *Config) ProxyGraph {
+	var rs dialer.RuleSet
+	for label := range cfg.Proxies {
+		rs.Add("."+label+".pacman", proxyDeps{label})
+	}
+	for _, r := range cfg.Rules {
+		for _, h := range r.Hosts {
+			rs.AddFiltered(h, r.Filter(), proxyDeps(r.Proxies))
+		}
+	}
+
+	g := make(ProxyGraph, len(cfg.Proxies))
+	for label, u := range cfg.Proxies {
+		if !dialer.Forwards(u.Scheme) {
+			continue
+		}
+		// the port is unknown when the URL relies on the scheme's default
+		port, _ := strconv.Atoi(u.Port())
+		if d, ok := rs.Match("tcp", u.Hostname(), port); ok && len(d.(proxyDeps)) > 0 {
+			g[label] = d.(proxyDeps)
+		}
+	}
+	return g
+}
+
+// proxyDeps stands in for a dialer so the graph is built with the same
+// matching as the ruleset.
+type proxyDeps []string
+
+func (proxyDeps) DialContext(context.Context, string, string) (net.Conn, error) {
+	return nil, errors.ErrUnsupported
+}
+
+// Cycle returns a path of proxies that depend on themselves, if any.
+// The first and last element of the path are the same proxy.
+func (g ProxyGraph) Cycle() []string {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int, len(g))
+	var path []string
+
+	var visit func(label string) []string
+	visit = func(label string) []string {
+		switch state[label] {
+		case visited:
+			return nil
+		case visiting:
+			for i, l := range path {
+				if l == label {
+					return append(path[i:], label)
+				}
+			}
+		}
+		state[label] = visiting
+		path = append(path, label)
+		for _, dep := range g[label] {
+			if cycle := visit(dep); cycle != nil {
+				return cycle
+			}
+		}
+		path = path[:len(path)-1]
+		state[label] = visited
+		return nil
+	}
+
+	for label := range iterutil.SortedMapIter(g) {
+		if cycle := visit(label); cycle != nil {
+			return cycle
+		}
+	}
+	return nil
+}
+
+// checkLoops rejects configs in which a proxy is reached through itself.
+func checkLoops(cfg *Config) error {
+	if cycle := NewProxyGraph(cfg).Cycle(); cycle != nil {
+		return fmt.Errorf("%w: %s", dialer.ErrLoop, strings.Join(cycle, " -> "))
+	}
+	return nil
+}
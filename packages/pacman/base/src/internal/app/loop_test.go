@@ -0,0 +1,34 @@
+package app
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/gilliginsisland/pacman/pkg/dialer"
+)
+
+func TestLoadConfigLoop(t *testing.T) {
+	cfg := parseConfig(t, `proxies:
+  a:
+    protocol: socks5
+    host: a.test:1080
+  b:
+    protocol: socks5
+    host: b.test:1080
+rules:
+  - hosts: [a.test]
+    proxies: [b]
+  - hosts: [b.test]
+    proxies: [a]
+`)
+	want := []string{"a", "b", "a"}
+	if got := NewProxyGraph(cfg).Cycle(); !slices.Equal(got, want) {
+		t.Errorf("got cycle %q, want %q", got, want)
+	}
+
+	var pacman PACMan
+	if err := pacman.LoadConfig(cfg); !errors.Is(err, dialer.ErrLoop) {
+		t.Errorf("got %v, want %v", err, dialer.ErrLoop)
+	}
+}
@@ -0,0 +1,141 @@
+package app
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/net/proxy"
+
+	"github.com/gilliginsisland/pacman/pkg/dialer"
+	"github.com/gilliginsisland/pacman/pkg/iterutil"
+	"github.com/gilliginsisland/pacman/pkg/metrics"
+)
+
+// MetricsPath is where the metrics are served.
+const MetricsPath = "/metrics"
+
+var metricsRegistry metrics.Registry
+
+var (
+	dialAttempts = metricsRegistry.Counter("pacman_dial_attempts_total",
+		"Connections dialed through a proxy.", "proxy", "rule")
+	dialSuccesses = metricsRegistry.Counter("pacman_dial_successes_total",
+		"Connections dialed through a proxy that succeeded.", "proxy", "rule")
+	dialFailures = metricsRegistry.Counter("pacman_dial_failures_total",
+		"Connections dialed through a proxy that failed.", "proxy", "rule")
+	dialDuration = metricsRegistry.Histogram("pacman_dial_duration_seconds",
+		"Time taken by successful dials through a proxy, including connecting the proxy.", metrics.DefBuckets, "proxy")
+	activeConnections = metricsRegistry.Gauge("pacman_active_connections",
+		"Open connections through a proxy.", "proxy")
+	transferredBytes = metricsRegistry.Counter("pacman_transferred_bytes_total",
+		"Bytes sent and received through proxy connections.", "proxy", "direction")
+	configReloads = metricsRegistry.Counter("pacman_config_reloads_total",
+		"Config reloads by result.", "result")
+	rejectedClients = metricsRegistry.Counter("pacman_rejected_clients_total",
+		"Connections closed because the client is not allowed on the listener.", "listener")
+)
+
+// registerMetrics adds the metrics read from the running instance.
+func (pacman *PACMan) registerMetrics() {
+	states := []dialer.ConnectionState{dialer.Offline, dialer.Connecting, dialer.Failed, dialer.Online}
+	metricsRegistry.GaugeFunc("pacman_proxy_state",
+		"State of a proxy, 1 for its current state and 0 for the others.", []string{"proxy", "state"},
+		func(emit func(float64, ...string)) {
+			pacman.mu.Lock()
+			defer pacman.mu.Unlock()
+			for label, pd := range iterutil.SortedMapIter(pacman.pool) {
+				current, _ := pd.State()
+				for _, state := range states {
+					v := 0.0
+					if state == current {
+						v = 1
+					}
+					emit(v, label, state.String())
+				}
+			}
+		})
+}
+
+type ruleKey struct{}
+
+// ruleDialer tells the proxies and the connection table
+// which rule a dial matched.
+type ruleDialer struct {
+	rule   string
+	dialer proxy.ContextDialer
+}
+
+func (d *ruleDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	if r, ok := ctx.Value(connRouteKey{}).(*connRoute); ok {
+		r.setRule(d.rule)
+	}
+	return d.dialer.DialContext(context.WithValue(ctx, ruleKey{}, d.rule), network, address)
+}
+
+// Unwrap returns the dialer of the rule.
+func (d *ruleDialer) Unwrap() proxy.ContextDialer {
+	return d.dialer
+}
+
+// meteredDialer records the dials through a proxy and the connections
+// they open, and the hops of the tracked connections.
+type meteredDialer struct {
+	label  string
+	dialer proxy.ContextDialer
+}
+
+func (d *meteredDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	rule, _ := ctx.Value(ruleKey{}).(string)
+	parent, _ := ctx.Value(hopKey{}).(*hop)
+	h := &hop{label: d.label}
+	if parent != nil {
+		ctx = context.WithValue(ctx, hopKey{}, h)
+	}
+	dialAttempts.With(d.label, rule).Inc()
+	start := time.Now()
+	conn, err := d.dialer.DialContext(ctx, network, address)
+	if parent != nil {
+		h.dialed(parent, err)
+	}
+	if err != nil {
+		dialFailures.With(d.label, rule).Inc()
+		return nil, err
+	}
+	dialSuccesses.With(d.label, rule).Inc()
+	dialDuration.With(d.label).Observe(time.Since(start).Seconds())
+	activeConnections.With(d.label).Inc()
+	return &meteredConn{Conn: conn, label: d.label}, nil
+}
+
+// meteredConn is a connection through a proxy, counted
+// as active until it is closed, that counts the bytes read and written.
+type meteredConn struct {
+	net.Conn
+	label string
+	once  sync.Once
+}
+
+func (c *meteredConn) Close() error {
+	c.once.Do(func() {
+		activeConnections.With(c.label).Dec()
+	})
+	return c.Conn.Close()
+}
+
+func (c *meteredConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		transferredBytes.With(c.label, "received").Add(float64(n))
+	}
+	return n, err
+}
+
+func (c *meteredConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if n > 0 {
+		transferredBytes.With(c.label, "sent").Add(float64(n))
+	}
+	return n, err
+}
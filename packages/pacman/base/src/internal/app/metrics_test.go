@@ -0,0 +1,52 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/proxy"
+)
+
+func TestMeteredConnTransfer(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		io.Copy(c, c)
+	}()
+
+	// the connection is used directly, as the HTTP proxy does, not joined
+	d := &meteredDialer{label: "metered", dialer: proxy.Direct}
+	conn, err := d.DialContext(context.Background(), "tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Write([]byte("ping"))
+	if _, err := io.ReadFull(conn, make([]byte, 4)); err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	var buf bytes.Buffer
+	metricsRegistry.WriteTo(&buf)
+	for _, want := range []string{
+		`pacman_transferred_bytes_total{proxy="metered",direction="sent"} 4`,
+		`pacman_transferred_bytes_total{proxy="metered",direction="received"} 4`,
+		`pacman_active_connections{proxy="metered"} 0`,
+	} {
+		if !strings.Contains(buf.String(), want+"\n") {
+			t.Errorf("metrics do not contain %s:\n%s", want, buf.String())
+		}
+	}
+}
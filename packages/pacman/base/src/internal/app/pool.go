@@ -2,6 +2,7 @@ package app
 
 import (
 	"context"
+	"fmt"
 	"net/url"
 	"strconv"
 	"time"
@@ -9,40 +10,68 @@ import (
 	"golang.org/x/net/proxy"
 
 	"github.com/gilliginsisland/pacman/pkg/dialer"
-	"github.com/gilliginsisland/pacman/pkg/iterutil"
-	"github.com/gilliginsisland/pacman/pkg/menuet"
-	"github.com/gilliginsisland/pacman/pkg/notify"
+	"github.com/gilliginsisland/pacman/pkg/syncutil"
 )
 
 type DialerPool map[string]*PooledDialer
 
-func (dp DialerPool) MenuItems() menuet.Itemer {
-	items := make(menuet.MenuItems, len(dp))
-	var idx int
-	for _, pd := range iterutil.SortedMapIter(dp) {
-		items[idx] = &pd.menu
-		idx++
-	}
-	return items
-}
-
 type PooledDialer struct {
 	Label  string
-	URL    *url.URL
+	URL    *URL
 	ctx    context.Context
 	cancel func()
 	dialer *dialer.Lazy
-	menu   menuet.MenuItem
-	child  menuet.MenuItem
+	dial   *meteredDialer // dialer used by the rules, records metrics
+	state  syncutil.AtomicValue[dialer.StateSignal]
+
+	health     *HealthCheck // running health check, nil if none
+	stopHealth context.CancelFunc
 }
 
-func NewPooledDialer(l string, u *url.URL, fwd proxy.Dialer) *PooledDialer {
-	var timeout time.Duration = 1 * time.Hour
-	if t := u.Query().Get("timeout"); t != "" {
-		if i, err := strconv.Atoi(t); err == nil {
-			timeout = time.Duration(i) * time.Second
-		}
+// DefaultTimeout is the idle timeout of a proxy without a timeout option.
+const DefaultTimeout = 1 * time.Hour
+
+// ParseTimeout returns the idle timeout set by the timeout option of u,
+// given in seconds. Zero disables the timeout.
+func ParseTimeout(u *url.URL) (time.Duration, error) {
+	t := u.Query().Get("timeout")
+	if t == "" {
+		return DefaultTimeout, nil
+	}
+	i, err := strconv.Atoi(t)
+	if err != nil || i < 0 {
+		return 0, fmt.Errorf("invalid timeout %q: must be a non-negative number of seconds", t)
+	}
+	return time.Duration(i) * time.Second, nil
+}
+
+// Reconnect is the policy for reconnecting a proxy automatically
+// after it failed.
+type Reconnect struct {
+	Attempts int      `json:"attempts"` // unlimited if zero
+	Initial  Duration `json:"initial"`  // dialer.DefaultRetryInitial if zero
+	Max      Duration `json:"max"`      // dialer.DefaultRetryMax if zero
+}
+
+func (r *Reconnect) retry() *dialer.Retry {
+	if r == nil {
+		return nil
 	}
+	return &dialer.Retry{
+		Initial:  time.Duration(r.Initial),
+		Max:      time.Duration(r.Max),
+		Attempts: r.Attempts,
+	}
+}
+
+// interactive reports whether connecting with the protocol may prompt the
+// user, so that it must not be retried without them.
+func interactive(protocol string) bool {
+	return protocol == "anyconnect" || protocol == "gp"
+}
+
+func NewPooledDialer(l string, u *URL, fwd proxy.Dialer) *PooledDialer {
+	timeout, _ := ParseTimeout(&u.URL) // checked by LoadConfig
 
 	ld := dialer.NewLazy(func(ctx context.Context) (proxy.Dialer, error) {
 		ctx, cancel := context.WithCancelCause(
@@ -51,6 +80,10 @@ func NewPooledDialer(l string, u *url.URL, fwd proxy.Dialer) *PooledDialer {
 		defer time.AfterFunc(2*time.Minute, func() {
 			cancel(context.DeadlineExceeded)
 		}).Stop()
+		u, err := u.Resolve(ctx)
+		if err != nil {
+			return nil, err
+		}
 		return dialer.FromURLContext(ctx, u, fwd)
 	}, timeout)
 
@@ -58,21 +91,17 @@ func NewPooledDialer(l string, u *url.URL, fwd proxy.Dialer) *PooledDialer {
 		Label:  l,
 		URL:    u,
 		dialer: ld,
+		dial:   &meteredDialer{label: l, dialer: ld},
 	}
 	pd.ctx, pd.cancel = context.WithCancel(context.Background())
-	pd.updateMenu(dialer.Offline)
+	pd.state.Store(dialer.StateSignal{State: dialer.Offline})
 	return &pd
 }
 
-func (pd *PooledDialer) updateMenu(state dialer.ConnectionState) {
-	pd.menu.Text = pd.icon(state) + " " + pd.Label
-	pd.menu.Badge = state.String()
-	if state == dialer.Offline {
-		pd.menu.Submenu = nil
-	} else {
-		pd.menu.Submenu = &pd.child
-		pd.child.Text, pd.child.Clicked = pd.action(state)
-	}
+// State returns the last connection state observed by Track.
+func (pd *PooledDialer) State() (dialer.ConnectionState, error) {
+	s := pd.state.Load()
+	return s.State, s.Err
 }
 
 func (pd *PooledDialer) Close() {
@@ -80,71 +109,11 @@ func (pd *PooledDialer) Close() {
 	pd.dialer.Close()
 }
 
-func (pd *PooledDialer) Track(cb func()) {
-	for state, err := range pd.dialer.Subscribe {
-		pd.updateMenu(state)
-		cb()
-		pd.notification(state, err)
-		if pd.ctx.Err() != nil {
-			break
-		}
-	}
-}
-
-func (pd *PooledDialer) icon(state dialer.ConnectionState) string {
-	switch state {
-	case dialer.Offline:
-		return "⚪"
-	case dialer.Online:
-		return "🟢"
-	case dialer.Failed:
-		return "🔴"
-	case dialer.Connecting:
-		return "🟡"
-	}
-	return ""
-}
-
-func (pd *PooledDialer) action(state dialer.ConnectionState) (string, func()) {
-	switch state {
-	case dialer.Offline:
-		return "Offline", nil
-	case dialer.Failed:
-		return "Reset", pd.dialer.Reset
-	case dialer.Online, dialer.Connecting:
-		return "Disconnect", pd.dialer.Close
-	}
-	return "", nil
-}
-
-func (pd *PooledDialer) notification(state dialer.ConnectionState, err error) {
-	notif := notify.Notification{
-		Identifier:          "status:" + pd.Label,
-		Title:               pd.Label,
-		PresentationOptions: menuet.NotificationPresentationOptionBanner,
-	}
-	switch state {
-	case dialer.Offline:
-		notif.Subtitle = "Proxy disconnected"
-		notif.Body = "The connection was terminated."
-	case dialer.Connecting:
-		notif.Subtitle = "Connecting to proxy"
-		notif.Body = "The connection to the proxy is being established."
-	case dialer.Online:
-		notif.Subtitle = "Proxy connected"
-		notif.Body = "The proxy connection has been established."
-	case dialer.Failed:
-		notif.Subtitle = "Proxy connection failed"
-		notif.Body = ""
-	default:
-		notif.Subtitle = "Unknown connection state"
-		notif.Body = "Dialer is in an unknown state."
-	}
-	if err != nil {
-		if notif.Body != "" {
-			notif.Body += "\n"
-		}
-		notif.Body += err.Error()
+// Track records every state change of the dialer after the one numbered
+// since and reports it to cb until the pooled dialer is closed.
+func (pd *PooledDialer) Track(since uint64, cb func(dialer.Event)) {
+	for e := range pd.dialer.Events(pd.ctx, since) {
+		pd.state.Store(e.Value)
+		cb(e)
 	}
-	notify.Notify(notif)
 }
@@ -1,65 +1,141 @@
 package app
 
 import (
-	"fmt"
-	"log/slog"
 	"net/http"
 	httpPprof "net/http/pprof"
 	"os"
 	"path/filepath"
 
 	"golang.org/x/crypto/ssh"
-	"tailscale.com/net/socks5"
 
 	"github.com/gilliginsisland/pacman/pkg/dialer"
 	"github.com/gilliginsisland/pacman/pkg/httpproxy"
 	"github.com/gilliginsisland/pacman/pkg/netutil"
+	"github.com/gilliginsisland/pacman/pkg/socksproxy"
 	"github.com/gilliginsisland/pacman/pkg/sshproxy"
 )
 
-func NewProxyServer(pd *dialer.ByHost) *netutil.MuxServer {
+// NewProxyServer serves the protocols of the listener, dialing through
+// pd and recording the connections in conns. When auth is not nil, proxy
+// clients must present credentials of the Auth it returns, and so must
+// clients of the control API, pprof and metrics. api serves the control API.
+func NewProxyServer(pd *dialer.ByHost, conns *ConnTable, lc *Listener, auth func() *Auth, api http.Handler) *netutil.MuxServer {
+	protocols := lc.Protocols
+	var (
+		password  func(username, password string) bool
+		publicKey func(key ssh.PublicKey) bool
+	)
+	if auth != nil {
+		password = func(username, password string) bool {
+			return auth().Password(username, password)
+		}
+		publicKey = func(key ssh.PublicKey) bool {
+			return auth().PublicKey(key)
+		}
+	}
+
 	s := netutil.NewMuxServer()
-	s.HandleServer(netutil.SOCKS5Match, &socks5.Server{
-		Dialer: pd.DialContext,
-		Logf: func(format string, v ...any) {
-			slog.Debug(fmt.Sprintf(format, v...))
-		},
-	})
-	s.HandleServer(netutil.SSHMatch, &sshproxy.Server{
-		Dialer: pd.DialContext,
-		HostKey: func() ssh.Signer {
-			homeDir, err := os.UserHomeDir()
-			if err != nil {
-				// fmt.Errorf("failed to get home directory: %w", err)
-				return nil
+	if protocols.Has(ProtocolSOCKS5) {
+		s.HandleServer(netutil.SOCKS5Match, &socksproxy.Server{
+			Dialer:       conns.Dialer(pd, ProtocolSOCKS5),
+			Authenticate: password,
+		})
+	}
+	if protocols.Has(ProtocolSOCKS4) {
+		srv := &socksproxy.SOCKS4Server{
+			Dialer: conns.Dialer(pd, ProtocolSOCKS4),
+		}
+		if auth != nil {
+			// SOCKS4 clients cannot send a password
+			srv.Authenticate = func(string) bool { return false }
+		}
+		s.HandleServer(netutil.SOCKS4Match, srv)
+	}
+	if protocols.Has(ProtocolSSH) {
+		s.HandleServer(netutil.SSHMatch, &sshproxy.Server{
+			Dialer:    conns.Dialer(pd, ProtocolSSH),
+			HostKey:   sshHostKey(),
+			PublicKey: publicKey,
+		})
+	}
+
+	protect := func(h http.Handler) http.Handler {
+		if password == nil {
+			return h
+		}
+		return httpproxy.RequireAuth(h, password)
+	}
+
+	mux := http.NewServeMux()
+	if protocols.Has(ProtocolPAC) {
+		var types []string
+		switch {
+		case lc.TLS != nil:
+			// PAC files have no type for SOCKS5 over TLS,
+			// and Listen requires http along with pac
+			types = append(types, "HTTPS")
+		default:
+			if protocols.Has(ProtocolSOCKS5) {
+				types = append(types, "SOCKS5")
 			}
-			keyPath := filepath.Join(homeDir, ".local", "state", "pacman", "ssh_host_key")
-			if err = os.MkdirAll(filepath.Dir(keyPath), 0o700); err != nil {
-				// fmt.Errorf("failed to create SSH key directory: %w", err)
-				return nil
+			if protocols.Has(ProtocolHTTP) {
+				types = append(types, "PROXY")
 			}
+		}
+		mux.Handle("/proxy.pac", &httpproxy.PacHandler{
+			Rules: pd.Patterns,
+			Types: types,
+		})
+	}
+	if protocols.Has(ProtocolPprof) {
+		pprofPrefix := "/debug/pprof/"
+		mux.Handle(pprofPrefix, protect(http.HandlerFunc(httpPprof.Index)))
+		mux.Handle(pprofPrefix+"cmdline", protect(http.HandlerFunc(httpPprof.Cmdline)))
+		mux.Handle(pprofPrefix+"profile", protect(http.HandlerFunc(httpPprof.Profile)))
+		mux.Handle(pprofPrefix+"symbol", protect(http.HandlerFunc(httpPprof.Symbol)))
+		mux.Handle(pprofPrefix+"trace", protect(http.HandlerFunc(httpPprof.Trace)))
+	}
+	if lc.serves(ProtocolAPI) {
+		if lc.Address.Network == "tcp" {
+			api = requireHost(api, lc.knownAs)
+		}
+		mux.Handle(APIPrefix, protect(api))
+	}
+	if protocols.Has(ProtocolMetrics) {
+		mux.Handle(MetricsPath, protect(&metricsRegistry))
+	}
+	switch {
+	case protocols.Has(ProtocolHTTP):
+		s.HandleServer(netutil.DefaultMatch, &httpproxy.Server{
+			Dialer:       conns.Dialer(pd, ProtocolHTTP),
+			Handler:      mux,
+			Authenticate: password,
+			ForwardedFor: lc.ForwardedFor,
+		})
+	case protocols.Has(ProtocolPAC) || protocols.Has(ProtocolPprof) || lc.serves(ProtocolAPI) || protocols.Has(ProtocolMetrics):
+		s.HandleServer(netutil.DefaultMatch, &http.Server{
+			Handler: mux,
+		})
+	}
+	return s
+}
 
-			s, err := sshproxy.LoadOrGenerateHostKey(keyPath)
-			if err != nil {
-				return nil
-			}
+func sshHostKey() ssh.Signer {
+	dir, err := StateDir.ExpandUser()
+	if err != nil {
+		// fmt.Errorf("failed to get home directory: %w", err)
+		return nil
+	}
+	keyPath := filepath.Join(dir, "ssh_host_key")
+	if err = os.MkdirAll(filepath.Dir(keyPath), 0o700); err != nil {
+		// fmt.Errorf("failed to create SSH key directory: %w", err)
+		return nil
+	}
+
+	s, err := sshproxy.LoadOrGenerateHostKey(keyPath)
+	if err != nil {
+		return nil
+	}
 
-			return s
-		}(),
-	})
-	mux := http.NewServeMux()
-	mux.Handle("/proxy.pac", &httpproxy.PacHandler{
-		Hosts: pd.Hosts,
-	})
-	pprofPrefix := "/debug/pprof/"
-	mux.HandleFunc(pprofPrefix, httpPprof.Index)
-	mux.HandleFunc(pprofPrefix+"cmdline", httpPprof.Cmdline)
-	mux.HandleFunc(pprofPrefix+"profile", httpPprof.Profile)
-	mux.HandleFunc(pprofPrefix+"symbol", httpPprof.Symbol)
-	mux.HandleFunc(pprofPrefix+"trace", httpPprof.Trace)
-	s.HandleServer(netutil.DefaultMatch, &httpproxy.Server{
-		Dialer:  pd.DialContext,
-		Handler: mux,
-	})
 	return s
 }
@@ -0,0 +1,70 @@
+package app
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/gilliginsisland/pacman/pkg/localca"
+)
+
+// StateDir is where PACman keeps the keys it generates.
+const StateDir Path = "~/.local/state/pacman"
+
+// ListenerTLS terminates TLS on a listener before its protocol is
+// sniffed. Without a certificate, one is issued by the local CA for
+// the names of the machine and Names.
+type ListenerTLS struct {
+	Cert  Path     `json:"cert"`  // PEM certificate chain
+	Key   Path     `json:"key"`   // PEM private key of the certificate
+	Names []string `json:"names"` // other host names clients connect to
+}
+
+// config returns the TLS config of the listener.
+func (t *ListenerTLS) config() (*tls.Config, error) {
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		NextProtos: []string{"http/1.1"},
+	}
+	switch {
+	case t.Cert == "" && t.Key == "":
+		ca, err := LocalCA()
+		if err != nil {
+			return nil, err
+		}
+		cfg.GetCertificate = ca.GetCertificate(func(name string) bool {
+			return localca.IsLocal(name) || slices.ContainsFunc(t.Names, func(n string) bool {
+				return strings.EqualFold(n, name)
+			})
+		})
+	case t.Cert == "" || t.Key == "":
+		return nil, errors.New("tls needs both cert and key, or neither for the local CA")
+	default:
+		certFile, err := t.Cert.ExpandUser()
+		if err != nil {
+			return nil, err
+		}
+		keyFile, err := t.Key.ExpandUser()
+		if err != nil {
+			return nil, err
+		}
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load tls certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// LocalCA loads the CA issuing the certificates of TLS listeners,
+// generating it in StateDir the first time.
+func LocalCA() (*localca.CA, error) {
+	dir, err := StateDir.ExpandUser()
+	if err != nil {
+		return nil, err
+	}
+	return localca.LoadOrCreate(dir)
+}
@@ -0,0 +1,85 @@
+package app
+
+import (
+	"log/slog"
+	"sync"
+
+	"github.com/gilliginsisland/pacman/pkg/dialer"
+)
+
+// UI presents a running PACMan to the user.
+type UI interface {
+	// Run calls fn while the UI event loop is running and returns its error.
+	// The UI is terminated once fn returns.
+	Run(fn func() error) error
+	// Terminate stops the UI, which makes Run return.
+	Terminate()
+	// Update redraws the UI after the proxy pool changed.
+	// It is called with the PACMan lock held.
+	Update(pacman *PACMan)
+	// StateChanged reports a connection state transition of a pooled dialer.
+	StateChanged(pd *PooledDialer, state dialer.ConnectionState, err error)
+	// Notify reports an informational message.
+	Notify(subtitle, body string)
+	// Alert reports an error.
+	Alert(subtitle string, err error)
+}
+
+var _ UI = (*Headless)(nil)
+
+// Headless is a UI that runs as a plain daemon and logs to slog.
+type Headless struct {
+	once sync.Once
+	done chan struct{}
+}
+
+func NewHeadless() *Headless {
+	return &Headless{
+		done: make(chan struct{}),
+	}
+}
+
+func (h *Headless) Run(fn func() error) error {
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- fn()
+	}()
+	select {
+	case err := <-errCh:
+		h.Terminate()
+		return err
+	case <-h.done:
+		return nil
+	}
+}
+
+func (h *Headless) Terminate() {
+	h.once.Do(func() {
+		close(h.done)
+	})
+}
+
+func (h *Headless) Update(pacman *PACMan) {}
+
+func (h *Headless) StateChanged(pd *PooledDialer, state dialer.ConnectionState, err error) {
+	attrs := []any{
+		slog.String("proxy", pd.Label),
+		slog.String("state", state.String()),
+	}
+	if err != nil {
+		attrs = append(attrs, slog.Any("error", err))
+	}
+	if state == dialer.Failed {
+		slog.Warn("proxy state changed", attrs...)
+	} else {
+		slog.Info("proxy state changed", attrs...)
+	}
+}
+
+func (h *Headless) Notify(subtitle, body string) {
+	slog.Info(subtitle, slog.String("message", body))
+}
+
+func (h *Headless) Alert(subtitle string, err error) {
+	slog.Error(subtitle, slog.Any("error", err))
+}
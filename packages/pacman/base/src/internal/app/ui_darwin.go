@@ -0,0 +1,236 @@
+//go:build darwin
+
+package app
+
+import (
+	"errors"
+
+	"github.com/gilliginsisland/pacman/docs"
+	"github.com/gilliginsisland/pacman/pkg/dialer"
+	"github.com/gilliginsisland/pacman/pkg/iterutil"
+	"github.com/gilliginsisland/pacman/pkg/menuet"
+	"github.com/gilliginsisland/pacman/pkg/notify"
+	"github.com/gilliginsisland/pacman/pkg/prompt"
+)
+
+// NewUI returns the UI for this platform.
+// Unless headless is set, this is the menu bar app.
+func NewUI(headless bool) UI {
+	if headless {
+		return NewHeadless()
+	}
+	prompt.SetDefault(notify.Prompter{})
+	return &MenuBar{
+		app: menuet.App(),
+	}
+}
+
+var _ UI = (*MenuBar)(nil)
+
+// MenuBar is a UI made of a status item and notifications.
+type MenuBar struct {
+	app     *menuet.Application
+	status  *menuet.StatusItem
+	proxies map[*PooledDialer]*proxyMenu
+}
+
+type proxyMenu struct {
+	item  menuet.MenuItem
+	child menuet.MenuItem
+}
+
+func (mb *MenuBar) Run(fn func() error) error {
+	var err error
+	mb.app.SetNotificationCategories(notify.Categories)
+	mb.app.Run(func() {
+		defer mb.app.Terminate()
+		err = fn()
+	})
+	return err
+}
+
+func (mb *MenuBar) Terminate() {
+	mb.app.Terminate()
+}
+
+func (mb *MenuBar) Update(pacman *PACMan) {
+	if mb.status == nil {
+		mb.status = mb.statusItem(pacman)
+	}
+	mb.app.UpdateStatusItem(mb.status)
+}
+
+func (mb *MenuBar) statusItem(pacman *PACMan) *menuet.StatusItem {
+	return &menuet.StatusItem{
+		Title: "PACman",
+		Image: "menuicon.pdf",
+		Submenu: menuet.Sections{
+			&menuet.Section{
+				Title: "Server Address",
+				Content: menuet.DynamicItem(func() menuet.Itemer {
+					items := make(menuet.MenuItems, len(pacman.listeners))
+					for i, l := range pacman.listeners {
+						items[i] = &menuet.MenuItem{
+							Text: l.String(),
+						}
+					}
+					return items
+				}),
+			},
+			menuet.DynamicItem(func() menuet.Itemer {
+				if len(pacman.pool) == 0 {
+					return &menuet.MenuItem{
+						Text: "No Proxies Configured",
+					}
+				}
+				return &menuet.Section{
+					Title:   "Proxies",
+					Content: mb.proxyItems(pacman.pool),
+				}
+			}),
+			&menuet.MenuItem{
+				Text: "Settings",
+				Submenu: (menuet.MenuItems{
+					&menuet.MenuItem{
+						Text:    "Edit",
+						Clicked: pacman.OpenConfig,
+					},
+					&menuet.MenuItem{
+						Text:    "Reload",
+						Clicked: pacman.ReloadConfig,
+					},
+					&menuet.MenuItemSeparator{},
+					&menuet.MenuItem{
+						Text: "Help",
+						Clicked: func() {
+							menuet.WebView(docs.HTML)
+						},
+					},
+					menuet.DynamicItem(func() menuet.Itemer {
+						action := &SparkleUpdateAction{}
+						if !mb.app.HasAction(action) {
+							return nil
+						}
+						return &menuet.MenuItems{
+							&menuet.MenuItemSeparator{},
+							&menuet.MenuItem{
+								Text:    "Check for Updates...",
+								Clicked: func() { mb.app.InvokeAction(action) },
+							},
+						}
+					}),
+				}),
+			},
+			&menuet.MenuItem{
+				Text:    "Quit",
+				Clicked: mb.app.Terminate,
+			},
+		},
+	}
+}
+
+// proxyItems renders the pool, reusing the menu items of known dialers
+// so their click handlers stay registered.
+func (mb *MenuBar) proxyItems(pool DialerPool) menuet.Itemer {
+	proxies := make(map[*PooledDialer]*proxyMenu, len(pool))
+	items := make(menuet.MenuItems, 0, len(pool))
+	for _, pd := range iterutil.SortedMapIter(pool) {
+		pm := mb.proxies[pd]
+		if pm == nil {
+			pm = &proxyMenu{}
+		}
+		proxies[pd] = pm
+		pm.update(pd)
+		items = append(items, &pm.item)
+	}
+	mb.proxies = proxies
+	return items
+}
+
+func (pm *proxyMenu) update(pd *PooledDialer) {
+	state, _ := pd.State()
+	pm.item.Text = icon(state) + " " + pd.Label
+	pm.item.Badge = state.String()
+	if state == dialer.Offline {
+		pm.item.Submenu = nil
+	} else {
+		pm.item.Submenu = &pm.child
+		pm.child.Text, pm.child.Clicked = action(pd, state)
+	}
+}
+
+func icon(state dialer.ConnectionState) string {
+	switch state {
+	case dialer.Offline:
+		return "⚪"
+	case dialer.Online:
+		return "🟢"
+	case dialer.Failed:
+		return "🔴"
+	case dialer.Connecting:
+		return "🟡"
+	}
+	return ""
+}
+
+func action(pd *PooledDialer, state dialer.ConnectionState) (string, func()) {
+	switch state {
+	case dialer.Offline:
+		return "Offline", nil
+	case dialer.Failed:
+		return "Reset", pd.dialer.Reset
+	case dialer.Online, dialer.Connecting:
+		return "Disconnect", pd.dialer.Close
+	}
+	return "", nil
+}
+
+func (mb *MenuBar) StateChanged(pd *PooledDialer, state dialer.ConnectionState, err error) {
+	notif := notify.Notification{
+		Identifier:          "status:" + pd.Label,
+		Title:               pd.Label,
+		PresentationOptions: menuet.NotificationPresentationOptionBanner,
+	}
+	switch state {
+	case dialer.Offline:
+		notif.Subtitle = "Proxy disconnected"
+		notif.Body = "The connection was terminated."
+	case dialer.Connecting:
+		notif.Subtitle = "Connecting to proxy"
+		notif.Body = "The connection to the proxy is being established."
+	case dialer.Online:
+		notif.Subtitle = "Proxy connected"
+		notif.Body = "The proxy connection has been established."
+	case dialer.Failed:
+		notif.Subtitle = "Proxy connection failed"
+		notif.Body = ""
+		var retry *dialer.RetryError
+		if errors.As(err, &retry) {
+			notif.Subtitle = "Proxy connection failed, retrying"
+		}
+	default:
+		notif.Subtitle = "Unknown connection state"
+		notif.Body = "Dialer is in an unknown state."
+	}
+	if err != nil {
+		if notif.Body != "" {
+			notif.Body += "\n"
+		}
+		notif.Body += err.Error()
+	}
+	notify.Notify(notif)
+}
+
+func (mb *MenuBar) Notify(subtitle, body string) {
+	notify.Notify(notify.Notification{
+		Subtitle: subtitle,
+		Body:     body,
+	})
+}
+
+func (mb *MenuBar) Alert(subtitle string, err error) {
+	notify.Notify(notify.Notification{
+		Subtitle: subtitle,
+		Body:     err.Error(),
+	})
+}
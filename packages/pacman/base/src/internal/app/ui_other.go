@@ -0,0 +1,9 @@
+//go:build !darwin
+
+package app
+
+// NewUI returns the UI for this platform.
+// Only the headless UI is available outside of darwin.
+func NewUI(headless bool) UI {
+	return NewHeadless()
+}
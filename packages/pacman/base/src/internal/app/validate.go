@@ -0,0 +1,277 @@
+package app
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"net"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/gilliginsisland/pacman/pkg/dialer"
+	"github.com/gilliginsisland/pacman/pkg/iterutil"
+	"github.com/gilliginsisland/pacman/pkg/trie"
+)
+
+// Diagnostic is a problem found in a config file.
+type Diagnostic struct {
+	File    string
+	Line    int // 0 if unknown
+	Message string
+}
+
+func (d Diagnostic) String() string {
+	if d.Line == 0 {
+		return d.File + ": " + d.Message
+	}
+	return fmt.Sprintf("%s:%d: %s", d.File, d.Line, d.Message)
+}
+
+// Validate checks the config for mistakes that parsing does not catch.
+// The diagnostics are ordered by file, then by their position in the file.
+func Validate(cfg *Config) []Diagnostic {
+	parts := cfg.parts
+	if len(parts) == 0 {
+		parts = []*Config{cfg}
+	}
+
+	var diags []Diagnostic
+	report := func(part *Config, line int, format string, args ...any) {
+		diags = append(diags, Diagnostic{
+			File:    part.Path.String(),
+			Line:    line,
+			Message: fmt.Sprintf(format, args...),
+		})
+	}
+
+	type seen struct {
+		pattern string
+		rule    int
+		file    string
+		line    int
+	}
+
+	// checkRule reports the problems of rule i, named name in the
+	// diagnostics, against the patterns of the earlier rules of its profile.
+	checkRule := func(part *Config, loc *locator, patterns map[string]seen, name string, i int, r *Rule) {
+		loc.rule()
+		for _, host := range r.Hosts {
+			line := loc.value(host)
+			if msg := checkPattern(host); msg != "" {
+				report(part, line, "%s: host pattern %q %s", name, host, msg)
+				continue
+			}
+			for _, key := range trie.Keys(host) {
+				// rules with different filters share a pattern without replacing each other
+				key += " " + r.Filter().String()
+				prev, ok := patterns[key]
+				if !ok {
+					patterns[key] = seen{host, i, part.Path.String(), line}
+					continue
+				}
+				where := fmt.Sprintf("line %d", prev.line)
+				if prev.file != part.Path.String() {
+					where = fmt.Sprintf("%s:%d", prev.file, prev.line)
+				}
+				// the later rule replaces the earlier one in the ruleset
+				if prev.pattern == host {
+					report(part, line, "%s: host pattern %q duplicates rule %d (%s), which it replaces", name, host, prev.rule, where)
+				} else {
+					report(part, line, "%s: host pattern %q replaces %q in rule %d (%s)", name, host, prev.pattern, prev.rule, where)
+				}
+				break
+			}
+		}
+		loc.rewind()
+		if err := r.check(); err != nil {
+			report(part, loc.value(string(r.Action)), "%s: %v", name, err)
+			loc.rewind()
+		}
+		for _, proxy := range r.Proxies {
+			line := loc.value(proxy)
+			if _, ok := cfg.Proxies[proxy]; !ok {
+				report(part, line, "%s: undefined proxy %q", name, proxy)
+			}
+		}
+		loc.rewind()
+		for _, hl := range r.HostLists {
+			line := loc.value(hl.Source)
+			if _, ok := cfg.Proxies[hl.Proxy]; hl.Proxy != "" && !ok {
+				report(part, line, "%s: host list %s: undefined proxy %q", name, hl, hl.Proxy)
+			}
+			hosts, err := hl.Cached()
+			if err != nil && !hl.IsURL() {
+				report(part, line, "%s: host list %s: %v", name, hl, err)
+			}
+			// entries are checked like hosts, but skipped rather than misread
+			for _, host := range hosts {
+				if msg := checkPattern(host); msg != "" {
+					report(part, line, "%s: host list %s: host pattern %q %s", name, hl, host, msg)
+				}
+			}
+		}
+	}
+
+	patterns := make(map[string]seen)
+	i := 0
+
+	for _, part := range parts {
+		loc := newLocator(part.source)
+
+		for label, u := range iterutil.SortedMapIter(part.Proxies) {
+			line := loc.key(label)
+			if !dialer.HasScheme(u.Scheme) {
+				report(part, line, "proxy %q: no dialer for protocol %q", label, u.Scheme)
+			}
+			if _, err := ParseTimeout(&u.URL); err != nil {
+				report(part, line, "proxy %q: %v", label, err)
+			}
+		}
+
+		if part == parts[0] {
+			// a loop may span files, report it against the file defining its first proxy
+			if cycle := NewProxyGraph(cfg).Cycle(); cycle != nil {
+				owner := parts[0]
+				for _, p := range parts {
+					if _, ok := p.Proxies[cycle[0]]; ok {
+						owner = p
+					}
+				}
+				report(owner, newLocator(owner.source).key(cycle[0]), "proxy loop: %s", strings.Join(cycle, " -> "))
+			}
+		}
+
+		for _, r := range part.Rules {
+			i++
+			checkRule(part, loc, patterns, fmt.Sprintf("rule %d", i), i, r)
+		}
+
+		// a profile is defined in a single file and replaces the rules
+		// only within itself
+		for name, rules := range iterutil.SortedMapIter(part.Profiles) {
+			loc.profile(name)
+			patterns := make(map[string]seen)
+			for j, r := range rules {
+				checkRule(part, loc, patterns, fmt.Sprintf("profile %q rule %d", name, j+1), j+1, r)
+			}
+		}
+	}
+
+	order := make(map[string]int, len(parts))
+	for i, part := range parts {
+		order[part.Path.String()] = i
+	}
+	slices.SortStableFunc(diags, func(a, b Diagnostic) int {
+		if c := cmp.Compare(order[a.File], order[b.File]); c != 0 {
+			return c
+		}
+		// diagnostics without a line go last
+		return cmp.Compare(uint(a.Line-1), uint(b.Line-1))
+	})
+	return diags
+}
+
+// checkPattern explains why trie.Trie.Insert would misread a host pattern,
+// or returns an empty string if the pattern is fine.
+func checkPattern(host string) string {
+	switch {
+	case host == "":
+		return "is empty"
+	case strings.TrimSpace(host) != host:
+		return "has surrounding whitespace"
+	case strings.Contains(host, "://"):
+		return "is a URL, not a host"
+	case strings.Contains(host, "/"):
+		if _, _, err := net.ParseCIDR(host); err != nil {
+			return "is not a valid CIDR and would be matched as a literal host name"
+		}
+		return ""
+	case strings.HasPrefix(host, "*") && !strings.HasPrefix(host, "*."):
+		return "would be matched as a literal host name, use \"*." + strings.TrimLeft(host, "*.") + "\" for a zone"
+	case strings.Contains(strings.TrimPrefix(host, "*."), "*"):
+		return "may only contain a wildcard as its first label"
+	case strings.Contains(host, ".."):
+		return "has an empty label"
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil && net.ParseIP(host) == nil {
+		return "contains a port, which is never matched (host " + h + ")"
+	}
+	return ""
+}
+
+// locator finds the line of config values in the source text.
+// Values of a rule are searched from the start of the rule onwards,
+// so repeated values resolve to successive occurrences.
+type locator struct {
+	src      []byte
+	proxies  int // offset of the proxies section
+	profiles int // offset of the profiles section
+	start    int // offset of the current rule
+	cursor   int // offset after the last value found
+	next     int // offset after the furthest value found in the current rule
+}
+
+func newLocator(src []byte) *locator {
+	l := locator{src: src}
+	if loc := regexp.MustCompile(`(?m)^proxies\s*:`).FindIndex(src); loc != nil {
+		l.proxies = loc[1]
+	}
+	if loc := regexp.MustCompile(`(?m)^profiles\s*:`).FindIndex(src); loc != nil {
+		l.profiles = loc[1]
+	}
+	if loc := regexp.MustCompile(`(?m)^rules\s*:`).FindIndex(src); loc != nil {
+		l.next = loc[1]
+	}
+	return &l
+}
+
+// rule starts searching values of the next rule.
+func (l *locator) rule() {
+	l.start, l.cursor = l.next, l.next
+}
+
+// profile searches the rules of the named profile next.
+func (l *locator) profile(name string) {
+	if line, end := l.find(keyPattern(name), l.profiles); line > 0 {
+		l.next = end
+	}
+}
+
+// rewind searches the current rule from its start again.
+func (l *locator) rewind() {
+	l.cursor = l.start
+}
+
+// key returns the line of a proxy label.
+func (l *locator) key(key string) int {
+	line, _ := l.find(keyPattern(key), l.proxies)
+	return line
+}
+
+// keyPattern matches a mapping key at the start of a line.
+func keyPattern(key string) *regexp.Regexp {
+	return regexp.MustCompile(`(?m)^\s*["']?(` + regexp.QuoteMeta(key) + `)["']?\s*:`)
+}
+
+// value returns the line of a scalar in the current rule.
+func (l *locator) value(value string) int {
+	re := regexp.MustCompile(`(?m)(?:^|[\s\[,\-:])["']?(` + regexp.QuoteMeta(value) + `)["']?\s*(?:$|[,\]#])`)
+	line, end := l.find(re, l.cursor)
+	if line > 0 {
+		l.cursor = end
+		l.next = max(l.next, end)
+	}
+	return line
+}
+
+// find returns the line of the first match at or after offset from,
+// and the offset where the matched value ends.
+// The value must be the first capture group of re.
+func (l *locator) find(re *regexp.Regexp, from int) (int, int) {
+	loc := re.FindSubmatchIndex(l.src[from:])
+	if loc == nil {
+		return 0, from
+	}
+	return bytes.Count(l.src[:from+loc[2]], []byte("\n")) + 1, from + loc[3]
+}
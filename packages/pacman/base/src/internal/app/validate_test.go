@@ -0,0 +1,251 @@
+package app
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// parseConfig parses the YAML config src written to a temporary file.
+func parseConfig(t *testing.T, src string) *Config {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(src), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := ParseConfigFile(Path(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		src    string
+		expect []string
+	}{
+		{
+			name: "valid",
+			src: `proxies:
+  vpn:
+    protocol: socks5
+    host: 127.0.0.1:1080
+rules:
+  - hosts: ["*.example.com", ".example.org", "10.0.0.0/8"]
+    proxies: [vpn]
+  - hosts: ["*.example.com"]
+    ports: [22]
+    action: direct
+`,
+		},
+		{
+			name: "proxies",
+			src: `proxies:
+  vpn:
+    protocol: nope
+    host: 127.0.0.1:1080
+  slow:
+    protocol: socks5
+    host: 127.0.0.1:1080
+    options:
+      timeout: soon
+rules:
+  - hosts: [example.com]
+    proxies: [vpn, missing]
+`,
+			expect: []string{
+				`:2: proxy "vpn": no dialer for protocol "nope"`,
+				`:5: proxy "slow": invalid timeout "soon": must be a non-negative number of seconds`,
+				`:12: rule 1: undefined proxy "missing"`,
+			},
+		},
+		{
+			name: "patterns",
+			src: `rules:
+  - hosts:
+      - "*example.com"
+      - "a.*.example.com"
+      - ".a..example.com"
+      - "10.0.0.0/33"
+      - "https://example.com/"
+      - "example.com:443"
+    action: direct
+`,
+			expect: []string{
+				`:3: rule 1: host pattern "*example.com" would be matched as a literal host name, use "*.example.com" for a zone`,
+				`:4: rule 1: host pattern "a.*.example.com" may only contain a wildcard as its first label`,
+				`:5: rule 1: host pattern ".a..example.com" has an empty label`,
+				`:6: rule 1: host pattern "10.0.0.0/33" is not a valid CIDR and would be matched as a literal host name`,
+				`:7: rule 1: host pattern "https://example.com/" is a URL, not a host`,
+				`:8: rule 1: host pattern "example.com:443" contains a port, which is never matched (host example.com)`,
+			},
+		},
+		{
+			name: "replaced",
+			src: `rules:
+  - hosts: [".example.com", "10.0.0.0/8"]
+    action: direct
+  - hosts:
+      - "*.example.com"
+      - example.com
+      - 10.0.0.0/8
+    action: reject
+  - hosts: ["*.example.com"]
+    ports: [443]
+    action: reject
+`,
+			expect: []string{
+				`:5: rule 2: host pattern "*.example.com" replaces ".example.com" in rule 1 (line 2)`,
+				`:6: rule 2: host pattern "example.com" replaces ".example.com" in rule 1 (line 2)`,
+				`:7: rule 2: host pattern "10.0.0.0/8" duplicates rule 1 (line 2), which it replaces`,
+			},
+		},
+		{
+			name: "profiles",
+			src: `profiles:
+  work:
+    - hosts: [".example.com"]
+      action: direct
+    - hosts: ["*example.com", example.com]
+      proxies: [missing]
+  home:
+    - hosts: [".example.com"]
+      action: reject
+rules:
+  - hosts: [".example.com"]
+    action: direct
+`,
+			expect: []string{
+				`:5: profile "work" rule 2: host pattern "*example.com" would be matched as a literal host name, use "*.example.com" for a zone`,
+				`:5: profile "work" rule 2: host pattern "example.com" replaces ".example.com" in rule 1 (line 3)`,
+				`:6: profile "work" rule 2: undefined proxy "missing"`,
+			},
+		},
+		{
+			name: "action with proxies",
+			src: `proxies:
+  vpn:
+    protocol: socks5
+    host: 127.0.0.1:1080
+rules:
+  - hosts: [example.com]
+    action: reject
+    proxies: [vpn]
+`,
+			expect: []string{
+				`:7: rule 1: rule with action reject cannot have proxies`,
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := parseConfig(t, tc.src)
+			var got []string
+			for _, d := range Validate(cfg) {
+				_, msg, _ := strings.Cut(d.String(), "config.yaml")
+				got = append(got, msg)
+			}
+			if !slices.Equal(got, tc.expect) {
+				t.Errorf("got:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(tc.expect, "\n"))
+			}
+		})
+	}
+}
+
+func TestValidateHostList(t *testing.T) {
+	list := filepath.Join(t.TempDir(), "hosts.txt")
+	if err := os.WriteFile(list, []byte("example.com\n*example.org\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := parseConfig(t, `rules:
+  - host_lists: ["`+list+`"]
+    action: direct
+`)
+	var got []string
+	for _, d := range Validate(cfg) {
+		got = append(got, d.Message)
+	}
+	want := []string{`rule 1: host list ` + list + `: host pattern "*example.org" would be matched as a literal host name, use "*.example.org" for a zone`}
+	if !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// the entry is skipped when the list is loaded
+	rl := runningList{list: cfg.Rules[0].HostLists[0]}
+	rl.load()
+	if hosts := rl.Hosts(); !slices.Equal(hosts, []string{"example.com"}) {
+		t.Errorf("got hosts %q, want %q", hosts, []string{"example.com"})
+	}
+}
+
+func TestLocator(t *testing.T) {
+	src := []byte(`# comment: example.com
+proxies:
+  "vpn": socks5://127.0.0.1:1080
+  ssh:
+    protocol: ssh
+rules:
+  - hosts: [example.com, example.com]
+    proxies:
+      - vpn
+  - hosts:
+      - 'example.com'
+    proxies: [ssh]
+`)
+	loc := newLocator(src)
+
+	if line := loc.key("vpn"); line != 3 {
+		t.Errorf("key vpn: got line %d, want 3", line)
+	}
+	if line := loc.key("ssh"); line != 4 {
+		t.Errorf("key ssh: got line %d, want 4", line)
+	}
+
+	steps := []struct {
+		value  string
+		rewind bool
+		expect int
+	}{
+		{"example.com", false, 7},
+		{"example.com", false, 7},
+		{"vpn", false, 9},
+		{"example.com", true, 7}, // searched again from the rule
+		{"missing", false, 0},
+	}
+	loc.rule()
+	for _, s := range steps {
+		if s.rewind {
+			loc.rewind()
+		}
+		if line := loc.value(s.value); line != s.expect {
+			t.Errorf("rule 1 value %q: got line %d, want %d", s.value, line, s.expect)
+		}
+	}
+
+	loc.rule()
+	if line := loc.value("example.com"); line != 11 {
+		t.Errorf("rule 2 value example.com: got line %d, want 11", line)
+	}
+	if line := loc.value("ssh"); line != 12 {
+		t.Errorf("rule 2 value ssh: got line %d, want 12", line)
+	}
+}
+
+func TestConfigCheckTimeout(t *testing.T) {
+	cfg := parseConfig(t, `proxies:
+  slow:
+    protocol: socks5
+    host: 127.0.0.1:1080
+    options:
+      timeout: "-1"
+`)
+	err := cfg.check()
+	if err == nil || !strings.Contains(err.Error(), `proxy slow: invalid timeout "-1"`) {
+		t.Errorf("got %v, want an invalid timeout error", err)
+	}
+}
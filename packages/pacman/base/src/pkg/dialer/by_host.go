@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"net"
+	"strconv"
 	"sync/atomic"
 
 	"golang.org/x/net/proxy"
@@ -32,21 +33,22 @@ func (d *ByHost) DialContext(ctx context.Context, network, address string) (net.
 	if err != nil {
 		return nil, err
 	}
+	// an unknown port would pass any port restriction of the rules
+	portnum, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, &net.AddrError{Err: "invalid port", Addr: address}
+	}
 
-	rs := d.rs.Load()
-	pd, ok := rs.Match(host)
-	// Check local resolver in case of /etc/hosts ip override
-	if ips, err := resolver.LookupIP(ctx, "ip", host); err == nil && len(ips) > 0 {
-		ip := ips[0].String()
-		if ip != host {
-			// If different then override host port
-			address, host = net.JoinHostPort(ip, port), ip
-			// If there was no hostname rule there may be an ip rule
-			if !ok {
-				pd, ok = rs.Match(host)
-			}
-		}
+	ctx, err = hop(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+
+	r, resolved, _ := d.Route(ctx, network, host, int(portnum))
+	if resolved != host {
+		address = net.JoinHostPort(resolved, port)
 	}
+	pd := r.Dialer
 	if pd == nil {
 		if d.Default != nil {
 			pd = d.Default
@@ -59,14 +61,50 @@ func (d *ByHost) DialContext(ctx context.Context, network, address string) (net.
 	return conn, err
 }
 
+// Route finds the rule for a connection the way DialContext does.
+// resolved is the host to connect to, which differs from host
+// when the local hosts file overrides it.
+func (d *ByHost) Route(ctx context.Context, network, host string, port int) (r Route, resolved string, ok bool) {
+	rs := d.rs.Load()
+	r, ok = rs.Lookup(network, host, port)
+	resolved = host
+	// Check local resolver in case of /etc/hosts ip override
+	if ips, err := resolver.LookupIP(ctx, "ip", host); err == nil && len(ips) > 0 {
+		ip := ips[0].String()
+		if ip != host {
+			// If different then override host
+			resolved = ip
+			// If there was no hostname rule there may be an ip rule
+			if !ok {
+				r, ok = rs.Lookup(network, ip, port)
+			}
+		}
+	}
+	return r, resolved, ok
+}
+
 // Swap installs a new ruleset atomically.
 // If newRS == nil, an empty RuleSet is installed.
 func (d *ByHost) Swap(rs *RuleSet) {
 	d.rs.Store(rs)
 }
 
-func (d *ByHost) Hosts(yield func(string) bool) {
-	if rs := d.rs.Load(); rs != nil {
-		rs.Hosts(yield)
+// Patterns iterates over the host patterns of the ruleset like
+// RuleSet.Patterns, with the dialer DialContext falls back to in place
+// of nil dialers.
+func (d *ByHost) Patterns(yield func(string, []Route) bool) {
+	def := d.Default
+	if def == nil {
+		def = proxy.Direct
+	}
+	for k, routes := range d.rs.Load().Patterns {
+		for i := range routes {
+			if routes[i].Dialer == nil {
+				routes[i].Dialer = def
+			}
+		}
+		if !yield(k, routes) {
+			return
+		}
 	}
 }
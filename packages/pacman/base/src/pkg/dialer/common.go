@@ -35,3 +35,18 @@ func dialContext(ctx context.Context, d proxy.Dialer, network, address string) (
 		return conn, err
 	}
 }
+
+// IsDirect reports whether d connects without a proxy, looking
+// through dialers that wrap another one with an Unwrap method.
+func IsDirect(d proxy.ContextDialer) bool {
+	for {
+		switch v := d.(type) {
+		case *net.Dialer:
+			return true
+		case interface{ Unwrap() proxy.ContextDialer }:
+			d = v.Unwrap()
+		default:
+			return d == proxy.Direct
+		}
+	}
+}
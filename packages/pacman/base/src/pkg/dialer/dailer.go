@@ -12,6 +12,10 @@ import (
 // from a URL with such a scheme.
 var ctxSchemes map[string]func(context.Context, *url.URL, proxy.Dialer) (proxy.Dialer, error)
 
+// directSchemes are registered schemes whose dialers establish their
+// connection on their own, without the forwarding Dialer.
+var directSchemes = map[string]bool{}
+
 // RegisterContextDialerType takes a URL scheme and a function to generate Dialers from
 // a URL with that scheme and a forwarding Dialer. Registered schemes are used
 // by FromURL.
@@ -27,6 +31,30 @@ func RegisterContextDialerType(scheme string, fn func(context.Context, *url.URL,
 	})
 }
 
+// RegisterDirectContextDialerType is like RegisterContextDialerType for
+// dialers that never use the forwarding Dialer, such as VPNs.
+func RegisterDirectContextDialerType(scheme string, fn func(context.Context, *url.URL, proxy.Dialer) (proxy.Dialer, error)) {
+	RegisterContextDialerType(scheme, fn)
+	directSchemes[scheme] = true
+}
+
+// Forwards reports whether dialers of the scheme connect to their proxy
+// through the forwarding Dialer.
+func Forwards(scheme string) bool {
+	return !directSchemes[scheme]
+}
+
+// HasScheme reports whether FromURLContext can create a Dialer for the scheme,
+// either from a registered type or one built into proxy.FromURL.
+func HasScheme(scheme string) bool {
+	switch scheme {
+	case "socks5", "socks5h":
+		return true
+	}
+	_, ok := ctxSchemes[scheme]
+	return ok
+}
+
 // FromURLContext behaves like proxy.FromURL but supports context and cancellation.
 //
 // If the scheme was registered with RegisterContextDialerType, it uses that.
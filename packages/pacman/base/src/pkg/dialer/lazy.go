@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"iter"
 	"net"
 	"sync"
 	"sync/atomic"
@@ -19,6 +20,9 @@ import (
 var (
 	ErrCloseRequested = errors.New("close requested")
 	ErrIdleTimeout    = errors.New("idle timeout reached")
+	ErrUnhealthy      = errors.New("connection unhealthy")
+	ErrReconnect      = errors.New("reconnect requested")
+	ErrNotOnline      = errors.New("dialer not online")
 )
 
 type waiter interface {
@@ -40,6 +44,10 @@ type StateSignal struct {
 	Err   error
 }
 
+// Event is a state change of a dialer, numbered in the order
+// of the changes of that dialer.
+type Event = syncutil.LogEntry[StateSignal]
+
 type Lazy struct {
 	new      func(ctx context.Context) (proxy.Dialer, error)
 	duration time.Duration
@@ -55,6 +63,12 @@ type Lazy struct {
 	ctx     context.Context
 	cancel  context.CancelCauseFunc
 	timeout *syncutil.Timeout
+
+	retry   atomic.Pointer[Retry]
+	attempt int                        // failures in a row, reset once online
+	backoff atomic.Pointer[time.Timer] // scheduled retry of a failed dialer, stored under mu
+
+	events syncutil.Log[StateSignal]
 }
 
 func NewLazy(new func(ctx context.Context) (proxy.Dialer, error), duration time.Duration) *Lazy {
@@ -71,13 +85,17 @@ func (d *Lazy) Dial(network, addr string) (net.Conn, error) {
 }
 
 func (d *Lazy) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	if initializing(ctx, d) {
+		return nil, fmt.Errorf("%w: proxy connection to %s requires itself", ErrLoop, addr)
+	}
+
 	d.mu.RLock()
 	for {
 		state := d.state
 		switch state {
 		case Offline:
 			if d.initing.CompareAndSwap(false, true) {
-				go d.init()
+				go d.init(ctx)
 			}
 		case Online:
 			if d.duration > 0 {
@@ -104,21 +122,56 @@ func (d *Lazy) DialContext(ctx context.Context, network, addr string) (net.Conn,
 	}
 }
 
-func (d *Lazy) Subscribe(yield func(ConnectionState, error) bool) {
-	var state ConnectionState
+// Probe dials through the connection if the dialer is online. Unlike
+// DialContext it never connects the dialer and does not count as
+// activity for the idle timeout.
+func (d *Lazy) Probe(ctx context.Context, network, addr string) (net.Conn, error) {
 	d.mu.RLock()
-	defer d.mu.RUnlock()
-	for {
-		d.cond.Wait()
-		if d.state != state {
-			state = d.state
-			if !yield(d.state, d.err) {
-				return
-			}
+	if d.state != Online {
+		d.mu.RUnlock()
+		return nil, ErrNotOnline
+	}
+	ctx = contextutil.Merge(ctx, d.ctx)
+	conn, err := dialContext(ctx, d.xd, network, addr)
+	context.AfterFunc(ctx, d.mu.RUnlock)
+	return conn, err
+}
+
+// SetRetry sets the policy for reconnecting after the dialer failed.
+// Without one a failed dialer stays failed until Reset.
+func (d *Lazy) SetRetry(r *Retry) {
+	d.retry.Store(r)
+}
+
+// Subscribe yields every state change of the dialer from now on.
+func (d *Lazy) Subscribe(yield func(ConnectionState, error) bool) {
+	for e := range d.Events(context.Background(), d.events.Last()) {
+		if !yield(e.Value.State, e.Value.Err) {
+			return
 		}
 	}
 }
 
+// Events yields the state changes after the one numbered seq, replaying
+// the recent ones still logged, until ctx is done. A subscriber that
+// falls far behind misses events, which shows as a gap in their numbers.
+func (d *Lazy) Events(ctx context.Context, seq uint64) iter.Seq[Event] {
+	return d.events.Follow(ctx, seq)
+}
+
+// LastEvent returns the number of the last state change, 0 if there was none.
+func (d *Lazy) LastEvent() uint64 {
+	return d.events.Last()
+}
+
+// setState changes the state and logs the change.
+// Must be called with the lock held.
+func (d *Lazy) setState(state ConnectionState, err error) {
+	d.state, d.err = state, err
+	d.events.Append(StateSignal{State: state, Err: err})
+	d.cond.Broadcast()
+}
+
 func (d *Lazy) Reset() {
 	d.mu.Lock()
 	defer d.mu.Unlock()
@@ -127,13 +180,49 @@ func (d *Lazy) Reset() {
 		return
 	}
 
-	d.state, d.err = Offline, nil
-	d.cond.Broadcast()
+	if t := d.backoff.Swap(nil); t != nil {
+		t.Stop()
+	}
+	d.attempt = 0
+	d.setState(Offline, nil)
+}
+
+// Connect starts connecting an offline dialer without waiting for a dial.
+func (d *Lazy) Connect() {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.state == Offline && d.initing.CompareAndSwap(false, true) {
+		go d.init(context.Background())
+	}
+}
+
+// Fail tears down the connection of an online dialer that stopped
+// working. The dialer stays Failed with err until Reset.
+func (d *Lazy) Fail(err error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.state == Online {
+		d.cancel(fmt.Errorf("%w: %w", ErrUnhealthy, err))
+	}
+}
+
+// Reconnect tears down the connection of an online dialer
+// and connects it again.
+func (d *Lazy) Reconnect(err error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.state == Online {
+		d.cancel(fmt.Errorf("%w: %w", ErrReconnect, err))
+	}
 }
 
 func (d *Lazy) Close() {
 	d.mu.RLock()
 	defer d.mu.RUnlock()
+	// under the lock, so that fail cannot schedule a retry after this
+	if t := d.backoff.Swap(nil); t != nil {
+		t.Stop()
+	}
 	if d.cancel != nil {
 		d.cancel(ErrCloseRequested)
 	} else {
@@ -142,11 +231,13 @@ func (d *Lazy) Close() {
 	}
 }
 
-func (d *Lazy) init() {
+// init connects the underlying dialer. The dial that triggered it is
+// only used to carry loop detection state into the connection attempt.
+func (d *Lazy) init(trigger context.Context) {
 	d.mu.Lock()
-	d.xd, d.state, d.err = nil, Connecting, nil
-	d.ctx, d.cancel = context.WithCancelCause(context.Background())
-	d.cond.Broadcast()
+	d.xd = nil
+	d.ctx, d.cancel = context.WithCancelCause(withInit(context.Background(), trigger, d))
+	d.setState(Connecting, nil)
 	d.mu.Unlock()
 
 	xd, err := d.new(d.ctx)
@@ -154,10 +245,10 @@ func (d *Lazy) init() {
 		d.mu.Lock()
 		defer d.mu.Unlock()
 		d.cancel(err)
-		d.xd, d.state, d.err = nil, Failed, context.Cause(d.ctx)
+		d.xd = nil
+		d.fail(context.Cause(d.ctx))
 		d.ctx, d.cancel, d.timeout = nil, nil, nil
 		d.initing.Store(false)
-		d.cond.Broadcast()
 		return
 	}
 
@@ -169,7 +260,7 @@ func (d *Lazy) init() {
 	}
 
 	d.mu.Lock()
-	d.xd, d.state, d.err = xd, Online, err
+	d.xd, d.attempt = xd, 0
 	if d.duration > 0 {
 		d.timeout = syncutil.NewTimeout(d.mu.RLocker(), d.duration, func() {
 			if d.state != Online {
@@ -179,27 +270,73 @@ func (d *Lazy) init() {
 			if c, ok := d.xd.(io.Closer); ok {
 				go c.Close()
 			}
-			d.xd, d.state, d.err = nil, Offline, context.Cause(d.ctx)
+			d.xd = nil
+			d.setState(Offline, context.Cause(d.ctx))
 			d.ctx, d.cancel, d.timeout = nil, nil, nil
-			d.cond.Broadcast()
 		})
 	}
 	d.initing.Store(false)
-	d.cond.Broadcast()
+	d.setState(Online, nil)
 	d.mu.Unlock()
 
 	context.AfterFunc(d.ctx, func() {
 		d.mu.Lock()
 		defer d.mu.Unlock()
-		if context.Cause(d.ctx) == ErrCloseRequested {
+		cause := context.Cause(d.ctx)
+		unhealthy, reconnect := errors.Is(cause, ErrUnhealthy), errors.Is(cause, ErrReconnect)
+		if cause == ErrCloseRequested || unhealthy || reconnect {
 			if c, ok := d.xd.(io.Closer); ok {
 				go c.Close()
 			}
 		}
-		d.xd, d.state, d.err = nil, Offline, context.Cause(d.ctx)
+		d.xd = nil
+		if unhealthy {
+			d.fail(cause)
+		} else {
+			d.setState(Offline, cause)
+		}
 		d.ctx, d.cancel, d.timeout = nil, nil, nil
-		d.cond.Broadcast()
+		if reconnect && d.initing.CompareAndSwap(false, true) {
+			go d.init(context.Background())
+		}
 	})
 
 	return
 }
+
+// fail moves the dialer to Failed and schedules a retry if the retry
+// policy allows another one. A dialer closed while connecting goes
+// Offline instead. Must be called with the lock held.
+func (d *Lazy) fail(err error) {
+	if errors.Is(err, ErrCloseRequested) {
+		d.attempt = 0
+		d.setState(Offline, err)
+		return
+	}
+	d.attempt++
+
+	r := d.retry.Load()
+	if r == nil || r.Attempts > 0 && d.attempt > r.Attempts {
+		d.setState(Failed, err)
+		return
+	}
+	delay := r.Delay(d.attempt)
+	d.setState(Failed, &RetryError{Attempt: d.attempt, Attempts: r.Attempts, At: time.Now().Add(delay), Err: err})
+	d.backoff.Store(time.AfterFunc(delay, d.retryFailed))
+}
+
+// retryFailed reconnects a dialer that failed, unless it was reset
+// or closed in the meantime.
+func (d *Lazy) retryFailed() {
+	d.mu.Lock()
+	if d.backoff.Swap(nil) == nil || d.state != Failed {
+		d.mu.Unlock()
+		return
+	}
+	d.setState(Offline, nil)
+	d.mu.Unlock()
+
+	if d.initing.CompareAndSwap(false, true) {
+		d.init(context.Background())
+	}
+}
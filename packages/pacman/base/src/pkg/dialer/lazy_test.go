@@ -0,0 +1,90 @@
+package dialer
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// nextState waits for the next state change of the dialer after seq.
+func nextState(t *testing.T, d *Lazy, seq uint64) Event {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for e := range d.Events(ctx, seq) {
+		return e
+	}
+	t.Fatalf("no state change after event %d", seq)
+	return Event{}
+}
+
+func TestLazyCloseWhileConnecting(t *testing.T) {
+	tests := []struct {
+		name  string
+		retry *Retry
+	}{
+		{name: "without retry"},
+		{name: "with retry", retry: &Retry{Initial: time.Millisecond, Max: time.Millisecond}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			d := NewLazy(func(ctx context.Context) (proxy.Dialer, error) {
+				<-ctx.Done()
+				return nil, context.Cause(ctx)
+			}, 0)
+			d.SetRetry(tc.retry)
+
+			d.Connect()
+			e := nextState(t, d, 0)
+			if e.Value.State != Connecting {
+				t.Fatalf("got %v, want %v", e.Value.State, Connecting)
+			}
+			d.Close()
+			e = nextState(t, d, e.Seq)
+			if e.Value.State != Offline || !errors.Is(e.Value.Err, ErrCloseRequested) {
+				t.Fatalf("got %v (%v), want %v (%v)", e.Value.State, e.Value.Err, Offline, ErrCloseRequested)
+			}
+
+			// no retry reconnects the closed dialer
+			time.Sleep(20 * time.Millisecond)
+			if last := d.LastEvent(); last != e.Seq {
+				t.Errorf("got %d state changes after closing, want none", last-e.Seq)
+			}
+		})
+	}
+}
+
+func TestLazyRetry(t *testing.T) {
+	errDown := errors.New("down")
+	d := NewLazy(func(ctx context.Context) (proxy.Dialer, error) {
+		return nil, errDown
+	}, 0)
+	d.SetRetry(&Retry{Initial: time.Millisecond, Max: time.Millisecond, Attempts: 1})
+
+	d.Connect()
+	var (
+		states []ConnectionState
+		seq    uint64
+	)
+	for range 4 {
+		e := nextState(t, d, seq)
+		states, seq = append(states, e.Value.State), e.Seq
+	}
+	want := []ConnectionState{Connecting, Failed, Offline, Connecting}
+	for i := range want {
+		if states[i] != want[i] {
+			t.Fatalf("got states %v, want %v", states, want)
+		}
+	}
+
+	// the retry fails too and is the last one
+	e := nextState(t, d, seq)
+	var retryErr *RetryError
+	if e.Value.State != Failed || errors.As(e.Value.Err, &retryErr) || !errors.Is(e.Value.Err, errDown) {
+		t.Errorf("got %v (%v), want %v (%v) without a retry", e.Value.State, e.Value.Err, Failed, errDown)
+	}
+}
@@ -0,0 +1,62 @@
+package dialer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// MaxHops is the number of nested ByHost dials allowed for one connection.
+const MaxHops = 16
+
+var ErrLoop = errors.New("proxy loop detected")
+
+type hopsKey struct{}
+
+// hops is carried in the dial context to detect dialers that,
+// directly or through other proxies, depend on themselves.
+type hops struct {
+	n      int
+	parent *hops
+	lazy   *Lazy // set if this hop is the initialisation of a Lazy
+}
+
+func hopsFrom(ctx context.Context) *hops {
+	h, _ := ctx.Value(hopsKey{}).(*hops)
+	return h
+}
+
+// hop records another nested dial in the context.
+func hop(ctx context.Context, address string) (context.Context, error) {
+	h := hopsFrom(ctx)
+	n := 1
+	if h != nil {
+		n = h.n + 1
+	}
+	if n > MaxHops {
+		return nil, fmt.Errorf("%w: dialing %s exceeded %d hops", ErrLoop, address, MaxHops)
+	}
+	return context.WithValue(ctx, hopsKey{}, &hops{n: n, parent: h}), nil
+}
+
+// initializing reports whether d is being initialised further up the
+// chain of dials in the context.
+func initializing(ctx context.Context, d *Lazy) bool {
+	for h := hopsFrom(ctx); h != nil; h = h.parent {
+		if h.lazy == d {
+			return true
+		}
+	}
+	return false
+}
+
+// withInit returns a context that carries the hops of parent,
+// marking the initialisation of d.
+func withInit(ctx, parent context.Context, d *Lazy) context.Context {
+	h := hopsFrom(parent)
+	n := 0
+	if h != nil {
+		n = h.n
+	}
+	return context.WithValue(ctx, hopsKey{}, &hops{n: n, parent: h, lazy: d})
+}
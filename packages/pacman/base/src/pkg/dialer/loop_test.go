@@ -0,0 +1,36 @@
+package dialer
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+)
+
+// dialFunc adapts a function to a dialer.
+type dialFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+func (f dialFunc) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	return f(ctx, network, address)
+}
+
+func TestByHostLoop(t *testing.T) {
+	// the proxy for loop.test is itself reached through loop.test,
+	// as happens when its host only matches the rule once resolved
+	var bh ByHost
+	var rs RuleSet
+	dials := 0
+	rs.Add("loop.test", dialFunc(func(ctx context.Context, network, address string) (net.Conn, error) {
+		dials++
+		return bh.DialContext(ctx, network, address)
+	}))
+	bh.Swap(&rs)
+
+	_, err := bh.DialContext(context.Background(), "tcp", "loop.test:80")
+	if !errors.Is(err, ErrLoop) {
+		t.Fatalf("got %v, want %v", err, ErrLoop)
+	}
+	if dials != MaxHops {
+		t.Errorf("got %d dials, want %d", dials, MaxHops)
+	}
+}
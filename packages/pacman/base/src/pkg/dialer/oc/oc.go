@@ -10,41 +10,13 @@ import (
 	"net/http"
 	"net/url"
 
-	"github.com/gilliginsisland/pacman/pkg/menuet"
-	"github.com/gilliginsisland/pacman/pkg/notify"
 	"github.com/gilliginsisland/pacman/pkg/openconnect"
 	"github.com/gilliginsisland/pacman/pkg/openconnect/hostscan"
+	"github.com/gilliginsisland/pacman/pkg/prompt"
 	"github.com/gilliginsisland/pacman/pkg/stackutil"
 	"github.com/gilliginsisland/pacman/pkg/xdg"
 )
 
-var NotificationCategories = []menuet.NotificationCategory{
-	{
-		Identifier: "yubi-auth",
-		Actions: []menuet.Actioner{
-			menuet.NotificationActionText{
-				NotificationAction: menuet.NotificationAction{
-					Identifier: "yubi-auth-token",
-					Title:      "Reply",
-				},
-				TextInputButtonTitle: "Submit",
-				TextInputPlaceholder: "Enter YubiKey OTP",
-			},
-		},
-		Options: menuet.CategoryOptionCustomDismiss,
-	},
-	{
-		Identifier: "external-browser-auth",
-		Actions: []menuet.Actioner{
-			menuet.NotificationAction{
-				Identifier: "external-browser-auth-open",
-				Title:      "Open",
-			},
-		},
-		Options: menuet.CategoryOptionCustomDismiss,
-	},
-}
-
 type callbacks struct {
 	url   *url.URL
 	ctx   context.Context
@@ -76,20 +48,13 @@ func (cb *callbacks) DebugLog(msg string, xtras ...slog.Attr) {
 }
 
 func (cb *callbacks) ExternalBrowser(uri string) error {
-	resp, err := notify.NotifyCtx(cb.ctx, notify.Notification{
-		CategoryIdentifier: "external-browser-auth",
-		Title:              cb.label,
-		Subtitle:           "Authentication Required",
-		Body:               "Click to complete authentication in browser",
-		InterruptionLevel:  menuet.NotificationInterruptionLevelTimeSensitive,
+	err := prompt.Default().Confirm(cb.ctx, prompt.Request{
+		Title:    cb.label,
+		Subtitle: "Authentication Required",
+		Message:  "Click to complete authentication in browser",
 	})
 	if err != nil {
-		cb.DebugLog("ExternalBrowser ctx cancelled", slog.Any("error", err))
-		return err
-	}
-	if resp.ActionIdentifier == menuet.DismissActionIdentifier {
-		err = errors.New("ExternalBrowser user cancelled")
-		cb.DebugLog("ExternalBrowser user cancelled", slog.Any("error", err))
+		cb.DebugLog("ExternalBrowser prompt failed", slog.Any("error", err))
 		return err
 	}
 	return xdg.Run(uri)
@@ -104,45 +69,17 @@ func (cb *callbacks) ProcessForm(form *openconnect.AuthForm) error {
 	cb.cp.Password, _ = cb.url.User.Password()
 
 	if cb.url.Query().Get("token") == "otp" {
-		resp, err := notify.NotifyCtx(cb.ctx, notify.Notification{
-			CategoryIdentifier: "yubi-auth",
-			Title:              cb.label,
-			Subtitle:           "Authentication Required",
-			Body:               "Enter YubiKey OTP",
-			InterruptionLevel:  menuet.NotificationInterruptionLevelTimeSensitive,
+		otp, err := prompt.Default().Input(cb.ctx, prompt.Request{
+			Title:       cb.label,
+			Subtitle:    "Authentication Required",
+			Message:     fmt.Sprintf("The proxy %s requires YubiKey OTP", cb.label),
+			Placeholder: "Enter YubiKey OTP",
 		})
 		if err != nil {
-			cb.DebugLog("AuthForm ctx cancelled", slog.Any("error", err))
-			return err
-		}
-		switch resp.ActionIdentifier {
-		case menuet.DismissActionIdentifier:
-			err := errors.New("auth form user cancelled")
-			cb.DebugLog("Auth form user cancelled", slog.Any("error", err))
+			cb.DebugLog("AuthForm prompt failed", slog.Any("error", err))
 			return err
-		case menuet.DefaultActionIdentifier:
-			resp, err := menuet.DisplayCtx(cb.ctx, menuet.Alert{
-				MessageText:     "YubiKey OTP Authentication Required",
-				InformativeText: fmt.Sprintf("The proxy %s requires YubiKey OTP", cb.label),
-				Inputs: []string{
-					"Enter YubiKey OTP",
-				},
-				Buttons: []string{
-					"Submit",
-					"Cancel",
-				},
-			})
-			if err != nil || resp.Button == 1 {
-				cb.DebugLog("AuthForm ctx cancelled", slog.Any("error", err))
-				if err != nil {
-					return err
-				}
-				return errors.New("auth form user cancelled")
-			}
-			cb.cp.Password += resp.Inputs[0]
-		case "yubi-auth-token":
-			cb.cp.Password += resp.Text
 		}
+		cb.cp.Password += otp
 		cb.DebugLog("AuthForm YOTP received")
 	}
 
@@ -10,8 +10,8 @@ import (
 )
 
 func init() {
-	RegisterContextDialerType("anyconnect", Openconnect)
-	RegisterContextDialerType("gp", Openconnect)
+	RegisterDirectContextDialerType("anyconnect", Openconnect)
+	RegisterDirectContextDialerType("gp", Openconnect)
 }
 
 func Openconnect(ctx context.Context, u *url.URL, fwd proxy.Dialer) (proxy.Dialer, error) {
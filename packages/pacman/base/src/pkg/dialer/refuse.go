@@ -0,0 +1,40 @@
+package dialer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+)
+
+var (
+	// ErrRejected is returned for connections a rule rejects.
+	// Servers report it to the client as a policy failure.
+	ErrRejected = errors.New("connection not allowed by ruleset")
+
+	// ErrDropped is returned for connections a rule drops.
+	// Servers close the client connection without a reply where the
+	// protocol allows it. It wraps ErrRejected.
+	ErrDropped = fmt.Errorf("%w, dropped", ErrRejected)
+)
+
+var (
+	// Reject fails every dial with ErrRejected.
+	Reject = Refuse{ErrRejected}
+
+	// Drop fails every dial with ErrDropped.
+	Drop = Refuse{ErrDropped}
+)
+
+// Refuse is a dialer that fails every dial with Err.
+type Refuse struct {
+	Err error
+}
+
+func (r Refuse) Dial(network, address string) (net.Conn, error) {
+	return r.DialContext(context.Background(), network, address)
+}
+
+func (r Refuse) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	return nil, &net.OpError{Op: "dial", Net: network, Err: fmt.Errorf("%s: %w", address, r.Err)}
+}
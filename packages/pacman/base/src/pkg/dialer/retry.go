@@ -0,0 +1,63 @@
+package dialer
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"time"
+)
+
+const (
+	DefaultRetryInitial = time.Second
+	DefaultRetryMax     = 5 * time.Minute
+)
+
+// Retry is the policy for reconnecting a failed dialer automatically.
+type Retry struct {
+	Initial  time.Duration // delay before the first retry, DefaultRetryInitial if zero
+	Max      time.Duration // longest delay, DefaultRetryMax if zero
+	Attempts int           // retries before giving up, unlimited if zero
+}
+
+// Delay returns the time to wait before the nth retry, starting at 1.
+// The delay doubles with every retry up to Max, and is randomized
+// between half and all of it so that failed dialers spread out.
+func (r *Retry) Delay(n int) time.Duration {
+	initial, max := r.Initial, r.Max
+	if initial <= 0 {
+		initial = DefaultRetryInitial
+	}
+	if max <= 0 {
+		max = DefaultRetryMax
+	}
+	d := initial
+	for i := 1; i < n && d < max; i++ {
+		d *= 2
+	}
+	d = min(d, max)
+	return d/2 + rand.N(d/2+1)
+}
+
+// RetryError is the error of a failed dialer that will reconnect.
+type RetryError struct {
+	Attempt  int       // the retry that is scheduled, starting at 1
+	Attempts int       // maximum retries, 0 if unlimited
+	At       time.Time // when the retry starts
+	Err      error     // why the dialer failed
+}
+
+func (e *RetryError) Error() string {
+	wait := time.Until(e.At)
+	if wait >= time.Second {
+		wait = wait.Round(time.Second)
+	} else {
+		wait = wait.Round(time.Millisecond)
+	}
+	if e.Attempts == 0 {
+		return fmt.Sprintf("%v (retry %d in %s)", e.Err, e.Attempt, wait)
+	}
+	return fmt.Sprintf("%v (retry %d of %d in %s)", e.Err, e.Attempt, e.Attempts, wait)
+}
+
+func (e *RetryError) Unwrap() error {
+	return e.Err
+}
@@ -1,39 +1,171 @@
 package dialer
 
 import (
+	"slices"
+	"strings"
+
 	"golang.org/x/net/proxy"
 
+	"github.com/gilliginsisland/pacman/pkg/netutil"
 	"github.com/gilliginsisland/pacman/pkg/trie"
 )
 
+// Filter restricts a rule to some ports and networks.
+// Empty fields match everything.
+type Filter struct {
+	Ports    []netutil.PortRange
+	Networks []netutil.Network
+}
+
+// Empty reports whether the filter matches everything.
+func (f *Filter) Empty() bool {
+	return len(f.Ports) == 0 && len(f.Networks) == 0
+}
+
+// Match reports whether a connection passes the filter.
+// A port of 0 is unknown and passes any port restriction.
+func (f *Filter) Match(network string, port int) bool {
+	if len(f.Networks) > 0 && !slices.ContainsFunc(f.Networks, func(n netutil.Network) bool {
+		return n.Match(network)
+	}) {
+		return false
+	}
+	if port != 0 && len(f.Ports) > 0 && !slices.ContainsFunc(f.Ports, func(r netutil.PortRange) bool {
+		return r.Contains(port)
+	}) {
+		return false
+	}
+	return true
+}
+
+func (f Filter) String() string {
+	var parts []string
+	if len(f.Ports) > 0 {
+		ports := make([]string, len(f.Ports))
+		for i, r := range f.Ports {
+			ports[i] = r.String()
+		}
+		parts = append(parts, "ports "+strings.Join(ports, ","))
+	}
+	if len(f.Networks) > 0 {
+		networks := make([]string, len(f.Networks))
+		for i, n := range f.Networks {
+			networks[i] = string(n)
+		}
+		parts = append(parts, "networks "+strings.Join(networks, ","))
+	}
+	return strings.Join(parts, " ")
+}
+
+type filtered struct {
+	Filter
+	pattern string // host pattern the rule was added with
+	dialer  proxy.ContextDialer
+}
+
+// entries are the rules stored under a single trie key. Filtered rules
+// come first, in the order they were added, followed by an unfiltered one.
+type entries struct {
+	rules []filtered
+}
+
+// add adds a rule, replacing an earlier one with the same filter.
+func (e *entries) add(entry filtered) {
+	if i := slices.IndexFunc(e.rules, func(o filtered) bool {
+		return o.Filter.String() == entry.Filter.String()
+	}); i >= 0 {
+		e.rules[i] = entry
+		return
+	}
+	i := len(e.rules)
+	if !entry.Empty() {
+		i = slices.IndexFunc(e.rules, func(o filtered) bool { return o.Empty() })
+		if i < 0 {
+			i = len(e.rules)
+		}
+	}
+	e.rules = slices.Insert(e.rules, i, entry)
+}
+
 // RuleSet wraps the trie of host → dialer mappings.
 type RuleSet struct {
-	trie trie.Trie[proxy.ContextDialer]
+	trie    trie.Trie[*entries]
+	entries map[string]*entries // by trie key, see trie.Keys
 }
 
 // Add parses a string specifying a host that should use the given proxy.
 // Each value is either an IP address, a CIDR range, a zone (*.example.com) or a
 // host name (example.com).
 func (rs *RuleSet) Add(host string, p proxy.ContextDialer) {
-	rs.trie.Insert(host, p)
+	rs.AddFiltered(host, Filter{}, p)
+}
+
+// AddFiltered is like Add, but the rule only applies to connections that
+// pass the filter. A rule for the same trie entry and filter as an earlier
+// one replaces it, so "*.example.com" replaces the zone of ".example.com",
+// but rules with other filters are kept.
+func (rs *RuleSet) AddFiltered(host string, f Filter, p proxy.ContextDialer) {
+	entry := filtered{Filter: f, pattern: host, dialer: p}
+	for _, key := range trie.Keys(host) {
+		e, ok := rs.entries[key]
+		if !ok {
+			if rs.entries == nil {
+				rs.entries = make(map[string]*entries)
+			}
+			e = &entries{}
+			rs.entries[key] = e
+			rs.trie.Insert(key, e)
+		}
+		e.add(entry)
+	}
 }
 
-// Hosts iterates over all hosts in the ruleset.
-func (rs *RuleSet) Hosts(yield func(string) bool) {
+// Patterns iterates over the host patterns of the ruleset, written as
+// trie.Trie.Walk does, with their rules in the order Lookup tries them.
+// A ".example.com" pattern is yielded as both example.com and
+// *.example.com. The routes have no Kind.
+func (rs *RuleSet) Patterns(yield func(string, []Route) bool) {
 	if rs == nil {
 		return
 	}
-	for k := range rs.trie.Walk {
-		if !yield(k) {
+	for k, e := range rs.trie.Walk {
+		routes := make([]Route, len(e.rules))
+		for i, entry := range e.rules {
+			routes[i] = Route{Pattern: entry.pattern, Filter: entry.Filter, Dialer: entry.dialer}
+		}
+		if !yield(k, routes) {
 			return
 		}
 	}
 }
 
-// Match finds the dialer for a host, if any.
-func (rs *RuleSet) Match(host string) (proxy.ContextDialer, bool) {
+// Route is the rule a connection matched.
+type Route struct {
+	Pattern string    // host pattern of the rule
+	Kind    trie.Kind // how the host matched the pattern
+	Filter  Filter
+	Dialer  proxy.ContextDialer
+}
+
+// Lookup finds the rule for a connection, if any. When the rules of the
+// most specific host pattern filter the connection out, less specific
+// patterns are tried.
+func (rs *RuleSet) Lookup(network, host string, port int) (Route, bool) {
 	if rs == nil {
-		return nil, false
+		return Route{}, false
 	}
-	return rs.trie.Match(host)
+	for kind, e := range rs.trie.MatchKinds(host) {
+		for _, entry := range e.rules {
+			if entry.Match(network, port) {
+				return Route{Pattern: entry.pattern, Kind: kind, Filter: entry.Filter, Dialer: entry.dialer}, true
+			}
+		}
+	}
+	return Route{}, false
+}
+
+// Match finds the dialer for a connection, if any, like Lookup.
+func (rs *RuleSet) Match(network, host string, port int) (proxy.ContextDialer, bool) {
+	r, ok := rs.Lookup(network, host, port)
+	return r.Dialer, ok
 }
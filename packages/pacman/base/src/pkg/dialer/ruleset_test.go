@@ -0,0 +1,74 @@
+package dialer
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"testing"
+
+	"golang.org/x/net/proxy"
+
+	"github.com/gilliginsisland/pacman/pkg/netutil"
+)
+
+// named is a dialer told apart by its name.
+type named string
+
+func (n named) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	return nil, ErrRejected
+}
+
+func ports(first, last uint16) Filter {
+	return Filter{Ports: []netutil.PortRange{{First: first, Last: last}}}
+}
+
+func TestRuleSetLookup(t *testing.T) {
+	var rs RuleSet
+	rs.AddFiltered(".example.com", ports(443, 443), named("dot-443"))
+	rs.AddFiltered("*.example.com", ports(22, 22), named("zone-22"))
+	rs.Add("*.EXAMPLE.com", named("zone"))
+	rs.Add("example.com", named("host"))
+	rs.AddFiltered("*.example.com", ports(22, 22), named("zone-22-again"))
+	rs.AddFiltered("*.example.com", Filter{Networks: []netutil.Network{"udp"}}, named("zone-udp"))
+	rs.Add("10.0.0.0/8", named("cidr-8"))
+	rs.AddFiltered("10.1.0.0/16", ports(80, 80), named("cidr-16-80"))
+
+	tests := []struct {
+		network string
+		address string
+		expect  proxy.ContextDialer
+		pattern string
+	}{
+		{"tcp", "a.example.com:443", named("dot-443"), ".example.com"},
+		{"tcp", "a.example.com:22", named("zone-22-again"), "*.example.com"},
+		{"tcp", "a.example.com:80", named("zone"), "*.EXAMPLE.com"},
+		{"udp", "a.example.com:53", named("zone-udp"), "*.example.com"},
+		{"tcp", "example.com:443", named("dot-443"), ".example.com"},
+		{"tcp", "example.com:22", named("host"), "example.com"},
+		{"tcp", "example.org:443", nil, ""},
+		{"tcp", "10.1.2.3:80", named("cidr-16-80"), "10.1.0.0/16"},
+		{"tcp", "10.1.2.3:443", named("cidr-8"), "10.0.0.0/8"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.network+" "+tc.address, func(t *testing.T) {
+			host, port, _ := net.SplitHostPort(tc.address)
+			p, _ := strconv.Atoi(port)
+			r, ok := rs.Lookup(tc.network, host, p)
+			if ok != (tc.expect != nil) || r.Dialer != tc.expect || r.Pattern != tc.pattern {
+				t.Errorf("got %v (%q), %v, want %v (%q)", r.Dialer, r.Pattern, ok, tc.expect, tc.pattern)
+			}
+		})
+	}
+}
+
+func TestByHostInvalidPort(t *testing.T) {
+	var bh ByHost
+	var rs RuleSet
+	rs.AddFiltered("*.example.com", ports(443, 443), proxy.Direct)
+	bh.Swap(&rs)
+	_, err := bh.DialContext(context.Background(), "tcp", "a.example.com:https")
+	if _, ok := err.(*net.AddrError); !ok {
+		t.Errorf("got %v, want an invalid port error", err)
+	}
+}
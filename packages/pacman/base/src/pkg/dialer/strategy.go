@@ -0,0 +1,231 @@
+package dialer
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"net"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// DefaultRaceDelay is how long Race waits for a dialer before starting the
+// next one, as recommended for Happy Eyeballs (RFC 8305).
+const DefaultRaceDelay = 250 * time.Millisecond
+
+var _ proxy.ContextDialer = (*Race)(nil)
+
+// Race starts the dialers one after another, each after the previous one
+// failed or took longer than Delay, and returns the first connection.
+// The dials still running are cancelled, the winning one keeps
+// the context of the caller.
+type Race struct {
+	Dialers []proxy.ContextDialer
+	Delay   time.Duration // DefaultRaceDelay if zero
+}
+
+func (r *Race) Dial(network, address string) (net.Conn, error) {
+	return r.DialContext(context.Background(), network, address)
+}
+
+func (r *Race) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	if len(r.Dialers) == 0 {
+		return nil, errors.New("no dialers to race")
+	}
+	delay := r.Delay
+	if delay == 0 {
+		delay = DefaultRaceDelay
+	}
+
+	// each dial has its own context, so that the losers can be cancelled
+	// while the connection of the winner stays bound to the caller's
+	var cancels []context.CancelFunc
+	cancelAll := func(except int) {
+		for i, cancel := range cancels {
+			if i != except {
+				cancel()
+			}
+		}
+	}
+
+	type result struct {
+		i    int
+		conn net.Conn
+		err  error
+	}
+	results := make(chan result)
+	done := make(chan struct{})
+	defer close(done)
+
+	var errs []error
+	running := 0
+	startNext := func() {
+		i := len(cancels)
+		dctx, cancel := context.WithCancel(ctx)
+		cancels = append(cancels, cancel)
+		running++
+		go func() {
+			conn, err := r.Dialers[i].DialContext(dctx, network, address)
+			select {
+			case results <- result{i, conn, err}:
+			case <-done:
+				if conn != nil {
+					conn.Close()
+				}
+			}
+		}()
+	}
+
+	startNext()
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	for running > 0 {
+		select {
+		case res := <-results:
+			running--
+			if res.err == nil {
+				cancelAll(res.i)
+				return res.conn, nil
+			}
+			cancels[res.i]()
+			errs = append(errs, res.err)
+			// a failed dial starts the next one right away
+			if len(cancels) < len(r.Dialers) {
+				startNext()
+				timer.Reset(delay)
+			}
+		case <-timer.C:
+			if len(cancels) < len(r.Dialers) {
+				startNext()
+				timer.Reset(delay)
+			}
+		case <-ctx.Done():
+			cancelAll(-1)
+			return nil, ctx.Err()
+		}
+	}
+	return nil, errors.Join(errs...)
+}
+
+var _ proxy.ContextDialer = (*RoundRobin)(nil)
+
+// RoundRobin starts each dial with the next dialer in turn,
+// falling back to the others in order if it fails.
+type RoundRobin struct {
+	Dialers []proxy.ContextDialer
+	next    atomic.Uint32
+}
+
+func (r *RoundRobin) Dial(network, address string) (net.Conn, error) {
+	return r.DialContext(context.Background(), network, address)
+}
+
+func (r *RoundRobin) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	n := len(r.Dialers)
+	if n == 0 {
+		return nil, errors.New("no dialers")
+	}
+	first := int(r.next.Add(1)-1) % n
+	return Chain(append(r.Dialers[first:n:n], r.Dialers[:first]...)).DialContext(ctx, network, address)
+}
+
+var _ proxy.ContextDialer = (*LeastLatency)(nil)
+
+// LeastLatency tries the dialers in the order of their recent dial
+// times, fastest first. Dialers without recent dials are tried first,
+// so that every dialer gets measured.
+type LeastLatency struct {
+	Dialers   []proxy.ContextDialer
+	Latencies *Latencies // shared, so measurements outlive the dialer
+}
+
+func (l *LeastLatency) Dial(network, address string) (net.Conn, error) {
+	return l.DialContext(context.Background(), network, address)
+}
+
+func (l *LeastLatency) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	lat := l.Latencies
+	if lat == nil {
+		lat = &defaultLatencies
+	}
+
+	ordered := slices.Clone(l.Dialers)
+	slices.SortStableFunc(ordered, func(a, b proxy.ContextDialer) int {
+		return cmp.Compare(lat.Get(a), lat.Get(b))
+	})
+
+	var errs []error
+	for _, d := range ordered {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		start := time.Now()
+		conn, err := d.DialContext(ctx, network, address)
+		if err != nil {
+			// a dial cancelled by the caller says nothing about the dialer
+			if ctx.Err() == nil {
+				lat.Fail(d)
+			}
+			errs = append(errs, err)
+			continue
+		}
+		lat.Observe(d, time.Since(start))
+		return conn, nil
+	}
+	return nil, errors.Join(errs...)
+}
+
+// failurePenalty is recorded as the dial time of a failed dial.
+const failurePenalty = 30 * time.Second
+
+// latencyWeight is the weight of a new measurement in the moving average.
+const latencyWeight = 0.3
+
+var defaultLatencies Latencies
+
+// Latencies keeps an exponentially weighted moving average
+// of the dial times of dialers. The dialers must be comparable.
+type Latencies struct {
+	mu sync.Mutex
+	m  map[proxy.ContextDialer]time.Duration
+}
+
+// Get returns the average dial time, or zero if the dialer has not
+// been measured.
+func (l *Latencies) Get(d proxy.ContextDialer) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.m[d]
+}
+
+// Observe records the time a successful dial took.
+func (l *Latencies) Observe(d proxy.ContextDialer, rtt time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.m == nil {
+		l.m = make(map[proxy.ContextDialer]time.Duration)
+	}
+	prev, ok := l.m[d]
+	if !ok {
+		l.m[d] = rtt
+		return
+	}
+	l.m[d] = time.Duration(latencyWeight*float64(rtt) + (1-latencyWeight)*float64(prev))
+}
+
+// Fail records a failed dial.
+func (l *Latencies) Fail(d proxy.ContextDialer) {
+	l.Observe(d, failurePenalty)
+}
+
+// Forget drops the measurements of a dialer that is no longer used.
+func (l *Latencies) Forget(d proxy.ContextDialer) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.m, d)
+}
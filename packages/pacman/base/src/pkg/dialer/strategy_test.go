@@ -0,0 +1,189 @@
+package dialer
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// fakeDialer dials a fakeConn after a delay, or fails with err.
+type fakeDialer struct {
+	name  string
+	delay time.Duration
+	err   error
+
+	mu   sync.Mutex
+	ctxs []context.Context
+}
+
+func (f *fakeDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	f.mu.Lock()
+	f.ctxs = append(f.ctxs, ctx)
+	f.mu.Unlock()
+	select {
+	case <-time.After(f.delay):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &fakeConn{name: f.name}, nil
+}
+
+// ctx returns the context of the last dial.
+func (f *fakeDialer) ctx() context.Context {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.ctxs) == 0 {
+		return nil
+	}
+	return f.ctxs[len(f.ctxs)-1]
+}
+
+type fakeConn struct {
+	net.Conn
+	name string
+}
+
+func (c *fakeConn) Close() error { return nil }
+
+// dialName dials and returns the name of the connection's dialer.
+func dialName(t *testing.T, ctx context.Context, d proxy.ContextDialer) (string, error) {
+	t.Helper()
+	conn, err := d.DialContext(ctx, "tcp", "example.com:443")
+	if err != nil {
+		return "", err
+	}
+	return conn.(*fakeConn).name, nil
+}
+
+func TestRace(t *testing.T) {
+	errRefused := errors.New("refused")
+
+	t.Run("faster dialer wins", func(t *testing.T) {
+		slow := &fakeDialer{name: "slow", delay: time.Hour}
+		fast := &fakeDialer{name: "fast"}
+		got, err := dialName(t, context.Background(), &Race{Dialers: []proxy.ContextDialer{slow, fast}, Delay: time.Millisecond})
+		if err != nil || got != "fast" {
+			t.Fatalf("got %q, %v, want %q", got, err, "fast")
+		}
+		select {
+		case <-slow.ctx().Done():
+		case <-time.After(5 * time.Second):
+			t.Error("the losing dial was not cancelled")
+		}
+		if err := fast.ctx().Err(); err != nil {
+			t.Errorf("got winning context error %v, want it bound to the caller's", err)
+		}
+	})
+
+	t.Run("failure starts the next dialer", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		failing := &fakeDialer{name: "failing", err: errRefused}
+		ok := &fakeDialer{name: "ok"}
+		got, err := dialName(t, ctx, &Race{Dialers: []proxy.ContextDialer{failing, ok}, Delay: time.Hour})
+		if err != nil || got != "ok" {
+			t.Fatalf("got %q, %v, want %q", got, err, "ok")
+		}
+	})
+
+	t.Run("all fail", func(t *testing.T) {
+		a := &fakeDialer{name: "a", err: errRefused}
+		b := &fakeDialer{name: "b", err: errRefused}
+		_, err := dialName(t, context.Background(), &Race{Dialers: []proxy.ContextDialer{a, b}})
+		if !errors.Is(err, errRefused) {
+			t.Errorf("got %v, want %v", err, errRefused)
+		}
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		slow := &fakeDialer{name: "slow", delay: time.Hour}
+		_, err := dialName(t, ctx, &Race{Dialers: []proxy.ContextDialer{slow}})
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("got %v, want %v", err, context.DeadlineExceeded)
+		}
+	})
+}
+
+func TestRoundRobin(t *testing.T) {
+	rr := &RoundRobin{Dialers: []proxy.ContextDialer{
+		&fakeDialer{name: "a"},
+		&fakeDialer{name: "b", err: errors.New("refused")},
+		&fakeDialer{name: "c"},
+	}}
+	// b falls back to the next dialer
+	for i, want := range []string{"a", "c", "c", "a"} {
+		got, err := dialName(t, context.Background(), rr)
+		if err != nil || got != want {
+			t.Errorf("dial %d: got %q, %v, want %q", i, got, err, want)
+		}
+	}
+}
+
+func TestLeastLatency(t *testing.T) {
+	slow := &fakeDialer{name: "slow"}
+	fast := &fakeDialer{name: "fast"}
+	failing := &fakeDialer{name: "failing", err: errors.New("refused")}
+	var lat Latencies
+	lat.Observe(slow, 100*time.Millisecond)
+	lat.Observe(fast, 10*time.Millisecond)
+	ll := &LeastLatency{Dialers: []proxy.ContextDialer{slow, fast, failing}, Latencies: &lat}
+
+	// the unmeasured dialer is tried first
+	got, err := dialName(t, context.Background(), ll)
+	if err != nil || got != "fast" {
+		t.Fatalf("got %q, %v, want %q", got, err, "fast")
+	}
+	if failing.ctx() == nil {
+		t.Error("the unmeasured dialer was not tried")
+	}
+	if d := lat.Get(failing); d != failurePenalty {
+		t.Errorf("got latency %v for the failed dialer, want %v", d, failurePenalty)
+	}
+
+	got, err = dialName(t, context.Background(), ll)
+	if err != nil || got != "fast" {
+		t.Errorf("got %q, %v, want %q", got, err, "fast")
+	}
+
+	// a dial cancelled by the caller is not counted as a failure
+	hanging := &fakeDialer{name: "hanging", delay: time.Hour}
+	ll = &LeastLatency{Dialers: []proxy.ContextDialer{hanging}, Latencies: &lat}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := dialName(t, ctx, ll); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want %v", err, context.DeadlineExceeded)
+	}
+	if d := lat.Get(hanging); d != 0 {
+		t.Errorf("got latency %v for the cancelled dialer, want 0", d)
+	}
+}
+
+func TestLatencies(t *testing.T) {
+	var lat Latencies
+	d := &fakeDialer{name: "d"}
+	if got := lat.Get(d); got != 0 {
+		t.Errorf("got %v before any dial, want 0", got)
+	}
+	lat.Observe(d, 100*time.Millisecond)
+	if got := lat.Get(d); got != 100*time.Millisecond {
+		t.Errorf("got %v after the first dial, want %v", got, 100*time.Millisecond)
+	}
+	lat.Observe(d, 200*time.Millisecond)
+	if got, want := lat.Get(d), 130*time.Millisecond; got != want {
+		t.Errorf("got %v, want the moving average %v", got, want)
+	}
+	lat.Forget(d)
+	if got := lat.Get(d); got != 0 {
+		t.Errorf("got %v after Forget, want 0", got)
+	}
+}
@@ -0,0 +1,175 @@
+// Package hostlist reads lists of host patterns from files and URLs.
+package hostlist
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Parse reads host patterns, one per line.
+// Blank lines and comments starting with # are skipped.
+func Parse(r io.Reader) ([]string, error) {
+	var hosts []string
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line, _, _ := strings.Cut(s.Text(), "#")
+		if line = strings.TrimSpace(line); line != "" {
+			hosts = append(hosts, line)
+		}
+	}
+	return hosts, s.Err()
+}
+
+// ReadFile reads the host patterns in a file.
+func ReadFile(name string) ([]string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Parse(f)
+}
+
+// ErrNotCached is returned by Fetcher.Cached if the list was never fetched.
+var ErrNotCached = errors.New("host list not cached")
+
+// ErrCacheWrite is wrapped by the error Fetcher.Fetch returns together
+// with the fetched hosts when only caching them failed.
+var ErrCacheWrite = errors.New("failed to cache host list")
+
+// Fetcher downloads a host list over HTTP. The list is cached on disk
+// with its ETag and Last-Modified headers, so an unchanged list is not
+// downloaded again and the last good copy survives failed fetches.
+type Fetcher struct {
+	URL    string
+	Client *http.Client
+	Dir    string // cache directory
+}
+
+type meta struct {
+	URL          string `json:"url"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+}
+
+func (f *Fetcher) path(ext string) string {
+	sum := sha256.Sum256([]byte(f.URL))
+	return filepath.Join(f.Dir, hex.EncodeToString(sum[:8])+ext)
+}
+
+// Cached returns the host patterns of the cached copy.
+func (f *Fetcher) Cached() ([]string, error) {
+	hosts, err := ReadFile(f.path(".txt"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotCached
+	}
+	return hosts, err
+}
+
+// Fetch downloads the list, unless the cached copy is still current,
+// and returns its host patterns. changed reports whether the list
+// differs from the cached copy. If the list cannot be cached, the hosts
+// are returned with an error wrapping ErrCacheWrite.
+func (f *Fetcher) Fetch(ctx context.Context) (hosts []string, changed bool, err error) {
+	var m meta
+	if data, err := os.ReadFile(f.path(".json")); err == nil {
+		json.Unmarshal(data, &m)
+	}
+	if _, err := os.Stat(f.path(".txt")); err != nil || m.URL != f.URL {
+		m = meta{}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, f.URL, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	if m.ETag != "" {
+		req.Header.Set("If-None-Match", m.ETag)
+	}
+	if m.LastModified != "" {
+		req.Header.Set("If-Modified-Since", m.LastModified)
+	}
+
+	client := f.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, false, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		hosts, err := f.Cached()
+		return hosts, false, err
+	case http.StatusOK:
+	default:
+		return nil, false, fmt.Errorf("fetching %s: %s", f.URL, resp.Status)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, false, err
+	}
+	hosts, err = Parse(bytes.NewReader(data))
+	if err != nil {
+		return nil, false, err
+	}
+
+	old, _ := os.ReadFile(f.path(".txt"))
+	changed = !bytes.Equal(old, data)
+
+	m = meta{
+		URL:          f.URL,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	}
+	if err := f.store(data, &m); err != nil {
+		return hosts, changed, fmt.Errorf("%w %s: %w", ErrCacheWrite, f.URL, err)
+	}
+	return hosts, changed, nil
+}
+
+func (f *Fetcher) store(data []byte, m *meta) error {
+	if err := os.MkdirAll(f.Dir, 0o755); err != nil {
+		return err
+	}
+	if err := writeFile(f.path(".txt"), data); err != nil {
+		return err
+	}
+	mdata, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return writeFile(f.path(".json"), mdata)
+}
+
+// writeFile replaces a file atomically.
+func writeFile(name string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(name), filepath.Base(name)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
@@ -0,0 +1,35 @@
+package hostlist
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestFetchCacheWrite(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "example.com # comment\n\n*.example.org\n")
+	}))
+	defer srv.Close()
+
+	// the cache directory cannot be created below a file
+	file := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(file, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	f := &Fetcher{URL: srv.URL, Dir: filepath.Join(file, "cache")}
+
+	hosts, changed, err := f.Fetch(context.Background())
+	if !errors.Is(err, ErrCacheWrite) {
+		t.Errorf("got error %v, want %v", err, ErrCacheWrite)
+	}
+	if want := []string{"example.com", "*.example.org"}; !slices.Equal(hosts, want) || !changed {
+		t.Errorf("got %q, changed %v, want %q, changed true", hosts, changed, want)
+	}
+}
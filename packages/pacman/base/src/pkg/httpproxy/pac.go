@@ -3,10 +3,15 @@ package httpproxy
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"iter"
 	"net"
 	"net/http"
+	"slices"
+	"strconv"
 	"strings"
+
+	"github.com/gilliginsisland/pacman/pkg/dialer"
 )
 
 func jsString(s string) string {
@@ -16,36 +21,221 @@ func jsString(s string) string {
 }
 
 // PacHandler generates a PAC file for browser proxy configuration.
+//
+// The PAC file routes a host like dialer.RuleSet.Lookup does: an exact
+// host first, then the CIDR ranges containing an IP address, most
+// specific first, or the zones containing a host name, most specific
+// first. Rules restricted to ports the URL does not use, or to any port
+// if the port of its scheme is unknown, are skipped in favour of less
+// specific patterns, and rules restricted to networks other than tcp
+// never apply. Hosts whose rule connects directly, and hosts matching
+// no rule, return DIRECT. Every other host is sent
+// through the proxy, which applies the rule itself.
 type PacHandler struct {
-	Hosts iter.Seq[string]
+	// Rules are the host patterns and their rules,
+	// as yielded by dialer.ByHost.Patterns.
+	Rules iter.Seq2[string, []dialer.Route]
+	// Types are the PAC proxy types served on the address of the
+	// request, in order of preference, "PROXY" if empty.
+	Types []string
 }
 
 func (h *PacHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/x-ns-proxy-autoconfig")
 
-	var checks []string
-
-	fmt.Fprintln(w, "function FindProxyForURL(url, host) {")
-	fmt.Fprintln(w, "\tswitch (host) {")
-	fmt.Fprintln(w, "\tdefault:")
-	fmt.Fprintln(w, "\t\tbreak;")
-	for host := range h.Hosts {
-		if strings.HasPrefix(host, "*.") {
-			host = strings.TrimPrefix(host, "*")
-			checks = append(checks, fmt.Sprintf("host.substring(host.length - %d) === %s", len(host), jsString(host)))
-		} else if _, ipnet, err := net.ParseCIDR(host); err == nil {
-			checks = append(checks, fmt.Sprintf("isInNet(host, \"%s\", \"%s\")", ipnet.IP.String(), net.IP(ipnet.Mask).String()))
+	types := h.Types
+	if len(types) == 0 {
+		types = []string{"PROXY"}
+	}
+	proxies := make([]string, len(types))
+	for i, t := range types {
+		proxies[i] = t + " " + r.Host
+	}
+
+	var p pac
+	for pattern, routes := range h.Rules {
+		p.add(pattern, routes)
+	}
+	p.write(w, strings.Join(proxies, "; "))
+}
+
+// pacNet is a CIDR range of the PAC file.
+type pacNet struct {
+	ipnet *net.IPNet
+	rules int
+}
+
+// pac is the routing table of a PAC file. Patterns refer to their rules
+// by index, so that patterns with the same rules share them.
+type pac struct {
+	rules []string       // JavaScript arrays of the rules of a pattern
+	index map[string]int // index of each of rules
+	hosts map[string]int
+	zones map[string]int
+	nets4 []pacNet // in the order of the trie, most specific first
+	nets6 []pacNet
+}
+
+func (p *pac) add(pattern string, routes []dialer.Route) {
+	rules := encodeRules(routes)
+	if rules == "" {
+		return
+	}
+	i, ok := p.index[rules]
+	if !ok {
+		if p.index == nil {
+			p.index = make(map[string]int)
+			p.hosts = make(map[string]int)
+			p.zones = make(map[string]int)
+		}
+		i = len(p.rules)
+		p.rules = append(p.rules, rules)
+		p.index[rules] = i
+	}
+
+	if _, ipnet, err := net.ParseCIDR(pattern); err == nil {
+		if ipnet.IP.To4() != nil {
+			p.nets4 = append(p.nets4, pacNet{ipnet, i})
 		} else {
-			fmt.Fprintf(w, "\tcase %s:\n", jsString(host))
+			p.nets6 = append(p.nets6, pacNet{ipnet, i})
+		}
+	} else if zone, ok := strings.CutPrefix(pattern, "*."); ok {
+		p.zones[zone] = i
+	} else {
+		p.hosts[pattern] = i
+	}
+}
+
+// encodeRules returns the rules of a pattern as a JavaScript array of
+// rules, each an array of 1 for direct or 0 for the proxy, followed by
+// the first and last port of each port range. The rules that never
+// apply to tcp are left out, and an empty string returned if none are left.
+func encodeRules(routes []dialer.Route) string {
+	var rules []string
+	for _, route := range routes {
+		if !route.Filter.Match("tcp", 0) {
+			continue
 		}
+		rule := []string{"0"}
+		if dialer.IsDirect(route.Dialer) {
+			rule[0] = "1"
+		}
+		for _, pr := range route.Filter.Ports {
+			rule = append(rule, strconv.Itoa(int(pr.First)), strconv.Itoa(int(pr.Last)))
+		}
+		rules = append(rules, "["+strings.Join(rule, ",")+"]")
+		if len(route.Filter.Ports) == 0 {
+			// later rules are never reached
+			break
+		}
+	}
+	if len(rules) == 0 {
+		return ""
+	}
+	return "[" + strings.Join(rules, ",") + "]"
+}
+
+func (p *pac) write(w io.Writer, proxy string) {
+	fmt.Fprintf(w, "var PROXY = %s;\n", jsString(proxy))
+	fmt.Fprintln(w, "var RULES = [")
+	for _, rules := range p.rules {
+		fmt.Fprintf(w, "\t%s,\n", rules)
+	}
+	fmt.Fprintln(w, "];")
+	writeMap(w, "HOSTS", p.hosts)
+	writeMap(w, "ZONES", p.zones)
+	fmt.Fprintln(w, "var NETS4 = [")
+	for _, n := range p.nets4 {
+		fmt.Fprintf(w, "\t[%s, %s, %d],\n", jsString(n.ipnet.IP.String()), jsString(net.IP(n.ipnet.Mask).String()), n.rules)
 	}
-	fmt.Fprintf(w, "\t\treturn \"PROXY %s\";\n", r.Host)
-	fmt.Fprintln(w, "\t}")
+	fmt.Fprintln(w, "];")
+	fmt.Fprintln(w, "var NETS6 = [")
+	for _, n := range p.nets6 {
+		fmt.Fprintf(w, "\t[%s, %d],\n", jsString(n.ipnet.String()), n.rules)
+	}
+	fmt.Fprintln(w, "];")
+	io.WriteString(w, pacFunctions)
+}
 
-	fmt.Fprintf(w, "\tif (%s) {\n", strings.Join(checks, " || "))
-	fmt.Fprintf(w, "\t\treturn \"PROXY %s\";\n", r.Host)
-	fmt.Fprintln(w, "\t}")
+func writeMap(w io.Writer, name string, m map[string]int) {
+	fmt.Fprintf(w, "var %s = {\n", name)
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	for _, k := range keys {
+		fmt.Fprintf(w, "\t%s: %d,\n", jsString(k), m[k])
+	}
+	fmt.Fprintln(w, "};")
+}
 
-	fmt.Fprintln(w, "\treturn \"DIRECT\";")
-	fmt.Fprintln(w, "}")
+// pacFunctions route with the tables written before them. isInNetEx,
+// needed for IPv6 ranges, is not available in every browser, in which
+// case IPv6 addresses only match exact hosts.
+const pacFunctions = `var PORTS = {http: 80, https: 443, ws: 80, wss: 443, ftp: 21};
+var IPV4 = /^(25[0-5]|2[0-4]\d|1\d\d|[1-9]?\d)(\.(25[0-5]|2[0-4]\d|1\d\d|[1-9]?\d)){3}$/;
+var has = Object.prototype.hasOwnProperty;
+
+function urlPort(url) {
+	var m = /^([a-z][a-z0-9+.-]*):\/\/(?:[^@\/?#]*@)?(?:\[[^\]]*\]|[^:\/?#]*)(?::(\d+))?/i.exec(url);
+	if (!m) {
+		return 0;
+	}
+	if (m[2]) {
+		return parseInt(m[2], 10);
+	}
+	return PORTS[m[1].toLowerCase()] || 0;
+}
+
+function route(i, port) {
+	var rules = RULES[i];
+	for (var r = 0; r < rules.length; r++) {
+		var rule = rules[r];
+		// the port of an unknown scheme is 0, which no port range matches
+		var match = rule.length == 1;
+		for (var p = 1; !match && p < rule.length; p += 2) {
+			match = port >= rule[p] && port <= rule[p + 1];
+		}
+		if (match) {
+			return rule[0] ? "DIRECT" : PROXY;
+		}
+	}
+	return null;
+}
+
+function FindProxyForURL(url, host) {
+	host = host.toLowerCase();
+	if (host.charAt(0) == "[") {
+		host = host.substring(1, host.length - 1);
+	}
+	var port = urlPort(url);
+	var result = null;
+	if (has.call(HOSTS, host) && (result = route(HOSTS[host], port))) {
+		return result;
+	}
+	if (IPV4.test(host)) {
+		for (var i = 0; i < NETS4.length; i++) {
+			if (isInNet(host, NETS4[i][0], NETS4[i][1]) && (result = route(NETS4[i][2], port))) {
+				return result;
+			}
+		}
+		return "DIRECT";
+	}
+	if (host.indexOf(":") >= 0) {
+		for (var i = 0; i < NETS6.length && typeof isInNetEx == "function"; i++) {
+			if (isInNetEx(host, NETS6[i][0]) && (result = route(NETS6[i][1], port))) {
+				return result;
+			}
+		}
+		return "DIRECT";
+	}
+	for (var i = host.indexOf("."); i >= 0; i = host.indexOf(".", i + 1)) {
+		var zone = host.substring(i + 1);
+		if (has.call(ZONES, zone) && (result = route(ZONES[zone], port))) {
+			return result;
+		}
+	}
+	return "DIRECT";
 }
+`
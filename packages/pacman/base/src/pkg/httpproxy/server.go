@@ -2,13 +2,18 @@ package httpproxy
 
 import (
 	"context"
+	"encoding/base64"
+	"errors"
 	"fmt"
-	"io"
 	"log/slog"
 	"net"
 	"net/http"
+	"net/http/httputil"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/gilliginsisland/pacman/pkg/dialer"
 	"github.com/gilliginsisland/pacman/pkg/netutil"
 )
 
@@ -16,13 +21,38 @@ import (
 type Server struct {
 	Dialer  func(ctx context.Context, network, address string) (net.Conn, error)
 	Handler http.Handler
-	Client  http.Client
+	// Transport sends forwarded requests, an http.Transport
+	// using Dialer without keep-alives if nil.
+	Transport http.RoundTripper
+	// Authenticate, if set, requires proxy requests to carry Basic
+	// credentials in Proxy-Authorization, and reports whether they are
+	// valid. Requests for Handler are not authenticated, wrap it with
+	// RequireAuth for that.
+	Authenticate func(username, password string) bool
+	// ForwardedFor appends the address of the client to the
+	// X-Forwarded-For header of forwarded requests. Otherwise
+	// X-Forwarded and Forwarded headers are not sent upstream.
+	ForwardedFor bool
 }
 
+// Realm is the realm of the Basic authentication challenge.
+const Realm = "PACman"
+
+// Pseudonym identifies the proxy in Via headers.
+const Pseudonym = "pacman"
+
+// FlushInterval is how often forwarded response bodies are flushed to
+// the client. Streamed responses, such as chunked responses and
+// server-sent events, are flushed after every write.
+const FlushInterval = 100 * time.Millisecond
+
 func (s *Server) Serve(l net.Listener) error {
-	if s.Client.Transport == nil {
-		s.Client.Transport = &http.Transport{
-			DialContext: s.Dialer,
+	if s.Transport == nil {
+		// every request is dialed for its own client and with the
+		// rules of the moment, and its connection ends with it
+		s.Transport = &http.Transport{
+			DialContext:       s.Dialer,
+			DisableKeepAlives: true,
 		}
 	}
 
@@ -30,7 +60,8 @@ func (s *Server) Serve(l net.Listener) error {
 }
 
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
+	ctx := netutil.WithClient(r.Context(), r.RemoteAddr)
+	r = r.WithContext(ctx)
 
 	slog.DebugContext(ctx,
 		"Serving http request",
@@ -40,6 +71,9 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	var err error
 	switch {
+	case (strings.ToUpper(r.Method) == http.MethodConnect || r.URL.IsAbs()) && !s.authorized(r):
+		w.Header().Set("Proxy-Authenticate", `Basic realm="`+Realm+`", charset="UTF-8"`)
+		http.Error(w, http.StatusText(http.StatusProxyAuthRequired), http.StatusProxyAuthRequired)
 	case strings.ToUpper(r.Method) == http.MethodConnect:
 		err = s.tunnel(w, r)
 	case r.URL.IsAbs():
@@ -66,6 +100,56 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// authorized reports whether the request may use the proxy. The
+// credentials are removed so that they are not sent upstream.
+func (s *Server) authorized(r *http.Request) bool {
+	if s.Authenticate == nil {
+		return true
+	}
+	auth := r.Header.Get("Proxy-Authorization")
+	if auth == "" {
+		// the client has not seen the challenge yet
+		return false
+	}
+	r.Header.Del("Proxy-Authorization")
+	if username, password, ok := parseBasic(auth); ok && s.Authenticate(username, password) {
+		return true
+	}
+	slog.WarnContext(r.Context(), "http proxy client rejected", slog.String("client", r.RemoteAddr))
+	return false
+}
+
+// RequireAuth requires requests for h to carry Basic credentials in
+// Authorization that authenticate reports as valid. Other requests
+// are challenged with 401 Unauthorized.
+func RequireAuth(h http.Handler, authenticate func(username, password string) bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, ok := r.BasicAuth()
+		if ok && authenticate(username, password) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if ok {
+			slog.WarnContext(r.Context(), "http client rejected", slog.String("client", r.RemoteAddr), slog.String("uri", r.RequestURI))
+		}
+		w.Header().Set("WWW-Authenticate", `Basic realm="`+Realm+`", charset="UTF-8"`)
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	})
+}
+
+// parseBasic returns the credentials of a Basic authorization header.
+func parseBasic(auth string) (username, password string, ok bool) {
+	scheme, credentials, ok := strings.Cut(auth, " ")
+	if !ok || !strings.EqualFold(scheme, "Basic") {
+		return "", "", false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(credentials))
+	if err != nil {
+		return "", "", false
+	}
+	return strings.Cut(string(decoded), ":")
+}
+
 func (s *Server) tunnel(w http.ResponseWriter, r *http.Request) error {
 	ctx, cancel := context.WithCancel(r.Context())
 	defer cancel()
@@ -80,7 +164,7 @@ func (s *Server) tunnel(w http.ResponseWriter, r *http.Request) error {
 	// connect to the destination (e.g. example.com:443)
 	destConn, err := s.Dialer(ctx, "tcp", r.Host)
 	if err != nil {
-		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		dialError(w, err, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
 		return fmt.Errorf("failed to connect to upstream %s: %w", r.Host, err)
 	}
 	defer destConn.Close()
@@ -104,32 +188,74 @@ func (s *Server) tunnel(w http.ResponseWriter, r *http.Request) error {
 	return nil
 }
 
+// forward sends a request for an absolute URI upstream as an RFC 9110
+// intermediary: hop-by-hop headers are removed, Via is added in both
+// directions, trailers are passed on, responses are streamed, and
+// upgraded connections such as WebSockets are tunnelled both ways.
 func (s *Server) forward(w http.ResponseWriter, r *http.Request) error {
-	ctx, cancel := context.WithCancel(r.Context())
-	defer cancel()
+	var err error
+	rp := &httputil.ReverseProxy{
+		Rewrite:        s.rewrite,
+		Transport:      s.Transport,
+		FlushInterval:  FlushInterval,
+		ModifyResponse: addVia,
+		ErrorLog:       slog.NewLogLogger(slog.Default().Handler(), slog.LevelDebug),
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, e error) {
+			err = e
+			dialError(w, e, e.Error(), http.StatusBadGateway)
+		},
+	}
+	rp.ServeHTTP(w, r)
+	return err
+}
 
-	req, err := http.NewRequestWithContext(ctx, r.Method, r.RequestURI, r.Body)
+// rewrite prepares the upstream request. The URI is already absolute,
+// and the hop-by-hop and X-Forwarded headers are already removed.
+func (s *Server) rewrite(pr *httputil.ProxyRequest) {
+	pr.Out.Header.Add("Via", via(pr.In.ProtoMajor, pr.In.ProtoMinor))
+	if !s.ForwardedFor {
+		return
+	}
+	client, _, err := net.SplitHostPort(pr.In.RemoteAddr)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return err
+		return
+	}
+	if prior := pr.In.Header.Values("X-Forwarded-For"); len(prior) > 0 {
+		client = strings.Join(prior, ", ") + ", " + client
 	}
-	req.Header = r.Header
+	pr.Out.Header.Set("X-Forwarded-For", client)
+}
 
-	resp, err := s.Client.Do(req)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadGateway)
-		return err
+// addVia adds the proxy to the Via header of the response.
+func addVia(resp *http.Response) error {
+	resp.Header.Add("Via", via(resp.ProtoMajor, resp.ProtoMinor))
+	return nil
+}
+
+// via returns the Via entry of the proxy for an HTTP version.
+func via(major, minor int) string {
+	if major >= 2 {
+		return strconv.Itoa(major) + " " + Pseudonym
 	}
-	defer resp.Body.Close()
+	return strconv.Itoa(major) + "." + strconv.Itoa(minor) + " " + Pseudonym
+}
 
-	header := w.Header()
-	for k, vv := range resp.Header {
-		for _, v := range vv {
-			header.Add(k, v)
+// dialError reports a failed upstream request to the client with the
+// given message and code. Rejected connections get a 403 instead, and
+// dropped ones no response at all.
+func dialError(w http.ResponseWriter, err error, msg string, code int) {
+	switch {
+	case errors.Is(err, dialer.ErrDropped):
+		if hj, ok := w.(http.Hijacker); ok {
+			if conn, _, err := hj.Hijack(); err == nil {
+				conn.Close()
+				return
+			}
 		}
+		fallthrough
+	case errors.Is(err, dialer.ErrRejected):
+		http.Error(w, dialer.ErrRejected.Error(), http.StatusForbidden)
+	default:
+		http.Error(w, msg, code)
 	}
-	w.WriteHeader(resp.StatusCode)
-
-	io.Copy(w, resp.Body)
-	return nil
 }
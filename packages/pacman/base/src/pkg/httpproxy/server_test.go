@@ -0,0 +1,354 @@
+package httpproxy
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gilliginsisland/pacman/pkg/netutil"
+)
+
+func TestServerAuth(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the credentials of the proxy are not sent upstream
+		io.WriteString(w, "backend "+r.Header.Get("Proxy-Authorization"))
+	}))
+	defer backend.Close()
+
+	authenticate := func(username, password string) bool {
+		return username == "alice" && password == "secret"
+	}
+	s := &Server{
+		Dialer: new(net.Dialer).DialContext,
+		Handler: RequireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, "handler")
+		}), authenticate),
+		Authenticate: authenticate,
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go s.Serve(l)
+	proxyURL := "http://" + l.Addr().String()
+
+	tests := []struct {
+		name      string
+		user      *url.Userinfo
+		proxied   bool // request the backend through the proxy
+		expect    int
+		body      string
+		challenge string // header with the challenge
+	}{
+		{name: "proxy without credentials", proxied: true, expect: http.StatusProxyAuthRequired, challenge: "Proxy-Authenticate"},
+		{name: "proxy with a wrong password", user: url.UserPassword("alice", "wrong"), proxied: true, expect: http.StatusProxyAuthRequired, challenge: "Proxy-Authenticate"},
+		{name: "proxy", user: url.UserPassword("alice", "secret"), proxied: true, expect: http.StatusOK, body: "backend "},
+		{name: "handler without credentials", expect: http.StatusUnauthorized, challenge: "WWW-Authenticate"},
+		{name: "handler with a wrong password", user: url.UserPassword("alice", "wrong"), expect: http.StatusUnauthorized, challenge: "WWW-Authenticate"},
+		{name: "handler", user: url.UserPassword("alice", "secret"), expect: http.StatusOK, body: "handler"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			target := proxyURL + "/api"
+			client := &http.Client{Transport: &http.Transport{}}
+			if tc.proxied {
+				u, _ := url.Parse(proxyURL)
+				u.User = tc.user
+				client.Transport = &http.Transport{Proxy: http.ProxyURL(u)}
+				target = backend.URL
+			}
+			req, _ := http.NewRequest(http.MethodGet, target, nil)
+			if !tc.proxied && tc.user != nil {
+				password, _ := tc.user.Password()
+				req.SetBasicAuth(tc.user.Username(), password)
+			}
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+			if resp.StatusCode != tc.expect {
+				t.Fatalf("got status %d, want %d", resp.StatusCode, tc.expect)
+			}
+			if tc.body != "" && string(body) != tc.body {
+				t.Errorf("got body %q, want %q", body, tc.body)
+			}
+			if want := `Basic realm="PACman", charset="UTF-8"`; tc.challenge != "" && resp.Header.Get(tc.challenge) != want {
+				t.Errorf("got %s %q, want %q", tc.challenge, resp.Header.Get(tc.challenge), want)
+			}
+		})
+	}
+
+	connect := []struct {
+		name          string
+		authorization string
+		expect        int
+	}{
+		{"connect without credentials", "", http.StatusProxyAuthRequired},
+		{"connect with a malformed header", "Basic !!!", http.StatusProxyAuthRequired},
+		{"connect", "Basic YWxpY2U6c2VjcmV0", http.StatusOK}, // alice:secret
+	}
+	for _, tc := range connect {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			conn, err := new(net.Dialer).DialContext(ctx, "tcp", l.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+			host := backend.Listener.Addr().String()
+			req, _ := http.NewRequest(http.MethodConnect, "", nil)
+			req.Host, req.URL = host, &url.URL{Opaque: host}
+			if tc.authorization != "" {
+				req.Header.Set("Proxy-Authorization", tc.authorization)
+			}
+			if err := req.Write(conn); err != nil {
+				t.Fatal(err)
+			}
+			resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tc.expect {
+				t.Errorf("got status %d, want %d", resp.StatusCode, tc.expect)
+			}
+		})
+	}
+}
+
+func TestServerForwardPerRequest(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "backend")
+	}))
+	defer backend.Close()
+
+	var (
+		mu      sync.Mutex
+		clients []string
+	)
+	s := &Server{
+		Dialer: func(ctx context.Context, network, address string) (net.Conn, error) {
+			mu.Lock()
+			clients = append(clients, netutil.Client(ctx))
+			mu.Unlock()
+			return new(net.Dialer).DialContext(ctx, network, address)
+		},
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go s.Serve(l)
+
+	// each request of a kept-alive client connection is dialed for itself
+	u, _ := url.Parse("http://" + l.Addr().String())
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(u)}}
+	for range 2 {
+		resp, err := client.Get(backend.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(clients) != 2 || clients[0] == "" || clients[0] != clients[1] {
+		t.Errorf("got dials for clients %q, want 2 for the same client", clients)
+	}
+}
+
+// serve serves s on a loopback listener and returns its address.
+func serve(t *testing.T, s *Server) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go s.Serve(l)
+	return l.Addr().String()
+}
+
+// send writes req to the proxy at addr and reads the response.
+func send(t *testing.T, addr string, req *http.Request) (*http.Response, net.Conn, *bufio.Reader) {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if err := req.WriteProxy(conn); err != nil {
+		t.Fatal(err)
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, conn, br
+}
+
+func TestServerForwardHeaders(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// echo the headers received
+		for k, v := range r.Header {
+			w.Header()["Got-"+k] = v
+		}
+		w.Header().Set("Keep-Alive", "timeout=5")
+		w.Header().Set("Proxy-Authenticate", `Basic realm="backend"`)
+	}))
+	defer backend.Close()
+
+	tests := []struct {
+		name         string
+		forwardedFor bool
+		expect       string // X-Forwarded-For received by the backend
+	}{
+		{"without x_forwarded_for", false, ""},
+		{"with x_forwarded_for", true, "192.0.2.1, 127.0.0.1"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			addr := serve(t, &Server{
+				Dialer:       new(net.Dialer).DialContext,
+				ForwardedFor: tc.forwardedFor,
+			})
+			req, _ := http.NewRequest(http.MethodGet, backend.URL, nil)
+			req.Header.Set("Connection", "X-Hop")
+			req.Header.Set("X-Hop", "1")
+			req.Header.Set("Keep-Alive", "timeout=5")
+			req.Header.Set("Proxy-Connection", "keep-alive")
+			req.Header.Set("Proxy-Authorization", "Basic YWxpY2U6c2VjcmV0")
+			req.Header.Set("TE", "trailers")
+			req.Header.Set("X-Forwarded-For", "192.0.2.1")
+			req.Header.Set("Forwarded", "for=192.0.2.1")
+			req.Header.Set("X-End-To-End", "1")
+			resp, _, _ := send(t, addr, req)
+			resp.Body.Close()
+
+			for _, h := range []string{"X-Hop", "Keep-Alive", "Proxy-Connection", "Proxy-Authorization", "Forwarded"} {
+				if v := resp.Header.Get("Got-" + h); v != "" {
+					t.Errorf("backend got %s %q, want it removed", h, v)
+				}
+			}
+			if v := resp.Header.Get("Got-X-End-To-End"); v != "1" {
+				t.Errorf("backend got X-End-To-End %q, want %q", v, "1")
+			}
+			if v := resp.Header.Get("Got-X-Forwarded-For"); v != tc.expect {
+				t.Errorf("backend got X-Forwarded-For %q, want %q", v, tc.expect)
+			}
+			if v := resp.Header.Get("Got-Via"); v != "1.1 pacman" {
+				t.Errorf("backend got Via %q, want %q", v, "1.1 pacman")
+			}
+			if v := resp.Header.Get("Via"); v != "1.1 pacman" {
+				t.Errorf("got Via %q, want %q", v, "1.1 pacman")
+			}
+			if v := resp.Header.Get("Keep-Alive") + resp.Header.Get("Proxy-Authenticate"); v != "" {
+				t.Errorf("got hop-by-hop headers of the backend %q, want them removed", v)
+			}
+		})
+	}
+}
+
+func TestServerForwardTrailers(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Trailer", "X-Checksum")
+		io.WriteString(w, "body")
+		w.Header().Set("X-Checksum", "abc")
+	}))
+	defer backend.Close()
+
+	addr := serve(t, &Server{Dialer: new(net.Dialer).DialContext})
+	req, _ := http.NewRequest(http.MethodGet, backend.URL, nil)
+	resp, _, _ := send(t, addr, req)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "body" || resp.Trailer.Get("X-Checksum") != "abc" {
+		t.Errorf("got body %q, trailer %q, want %q, %q", body, resp.Trailer.Get("X-Checksum"), "body", "abc")
+	}
+}
+
+func TestServerForwardEvents(t *testing.T) {
+	done := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, "data: 1\n\n")
+		w.(http.Flusher).Flush()
+		// the stream stays open until the client got the event
+		<-done
+	}))
+	defer backend.Close()
+	defer close(done)
+
+	addr := serve(t, &Server{Dialer: new(net.Dialer).DialContext})
+	req, _ := http.NewRequest(http.MethodGet, backend.URL, nil)
+	// the body is not closed, that would wait for the end of the stream
+	resp, _, _ := send(t, addr, req)
+	event, err := bufio.NewReader(resp.Body).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event != "data: 1\n" {
+		t.Errorf("got %q, want %q", event, "data: 1\n")
+	}
+}
+
+func TestServerForwardUpgrade(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "websocket" {
+			http.Error(w, "upgrade required", http.StatusUpgradeRequired)
+			return
+		}
+		conn, brw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		brw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n")
+		brw.Flush()
+		// echo the frames both ways
+		io.Copy(conn, brw)
+	}))
+	defer backend.Close()
+
+	addr := serve(t, &Server{Dialer: new(net.Dialer).DialContext})
+	req, _ := http.NewRequest(http.MethodGet, backend.URL, nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	resp, conn, br := send(t, addr, req)
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("got status %d, want %d", resp.StatusCode, http.StatusSwitchingProtocols)
+	}
+
+	for _, msg := range []string{"ping", "pong"} {
+		conn.Write([]byte(msg))
+		got := make([]byte, len(msg))
+		if _, err := io.ReadFull(br, got); err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != msg {
+			t.Errorf("got %q, want %q", got, msg)
+		}
+	}
+}
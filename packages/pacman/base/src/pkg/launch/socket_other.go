@@ -0,0 +1,12 @@
+//go:build !darwin
+
+package launch
+
+import (
+	"errors"
+	"net"
+)
+
+func ActivateSocket(name string) ([]net.Listener, error) {
+	return nil, errors.New("launchd socket activation is only supported on darwin")
+}
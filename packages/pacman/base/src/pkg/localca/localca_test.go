@@ -0,0 +1,77 @@
+package localca
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+	"testing"
+)
+
+func TestGetCertificate(t *testing.T) {
+	dir := t.TempDir()
+	ca, err := LoadOrCreate(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the CA is loaded again on the next start
+	if ca, err = LoadOrCreate(dir); err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.Cert)
+
+	get := ca.GetCertificate(func(name string) bool {
+		return name == "pacman.test" || IsLocal(name)
+	})
+	tests := []struct {
+		name    string
+		allowed bool
+	}{
+		{"pacman.test", true},
+		{"localhost", true},
+		{"127.0.0.1", true},
+		{"example.com", false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			cert, err := get(&tls.ClientHelloInfo{ServerName: tc.name})
+			if !tc.allowed {
+				if err == nil {
+					t.Errorf("got a certificate for %s, want an error", tc.name)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = cert.Leaf.Verify(x509.VerifyOptions{DNSName: tc.name, Roots: roots})
+			if err != nil {
+				t.Errorf("certificate does not verify: %v", err)
+			}
+		})
+	}
+}
+
+func TestIsLocal(t *testing.T) {
+	host, err := os.Hostname()
+	if err != nil {
+		t.Skip(err)
+	}
+	tests := []struct {
+		name   string
+		expect bool
+	}{
+		{"localhost", true},
+		{"LOCALHOST.", true},
+		{"app.localhost", true},
+		{"::1", true},
+		{host, true},
+		{"example.com", false},
+		{"192.0.2.1", false},
+	}
+	for _, tc := range tests {
+		if got := IsLocal(tc.name); got != tc.expect {
+			t.Errorf("IsLocal(%q) = %v, want %v", tc.name, got, tc.expect)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+// Package logfile writes log files that rotate by size and age.
+package logfile
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// timeFormat is the suffix of rotated files, which sorts by time.
+const timeFormat = "20060102T150405.000"
+
+// Writer appends to the file at Path. Before a write that would make
+// the file larger than MaxSize, or once the file is MaxAge old, the
+// file is renamed with the time as a suffix and a new one is started.
+// The age of an existing file counts from before the Writer opened it.
+// Writer is safe for concurrent use.
+type Writer struct {
+	Path    string
+	MaxSize int64         // bytes, unlimited if zero
+	MaxAge  time.Duration // unlimited if zero
+	Backups int           // rotated files kept, all of them if zero
+
+	mu      sync.Mutex
+	f       *os.File
+	size    int64
+	started time.Time // when the file was created
+}
+
+// Write writes p to the file, rotating it first if needed. Each call
+// is written to a single file, so callers should write whole lines.
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.f == nil {
+		if err := w.open(); err != nil {
+			return 0, err
+		}
+	}
+	if w.size > 0 && (w.MaxSize > 0 && w.size+int64(len(p)) > w.MaxSize ||
+		w.MaxAge > 0 && time.Since(w.started) >= w.MaxAge) {
+		if err := w.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := w.f.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// Close closes the file. A later write opens it again.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.f == nil {
+		return nil
+	}
+	err := w.f.Close()
+	w.f = nil
+	return err
+}
+
+func (w *Writer) open() error {
+	if err := os.MkdirAll(filepath.Dir(w.Path), 0o700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(w.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	w.f, w.size, w.started = f, fi.Size(), w.created(fi)
+	return nil
+}
+
+// created returns when the file was started, which is when the newest
+// rotated file was renamed. Without one, the file is as old as its last
+// write, so that its age counts from before a restart.
+func (w *Writer) created(fi os.FileInfo) time.Time {
+	if fi.Size() == 0 {
+		return time.Now()
+	}
+	backups, _ := filepath.Glob(w.Path + ".*")
+	slices.Sort(backups)
+	for _, b := range slices.Backward(backups) {
+		t, err := time.ParseInLocation(timeFormat, strings.TrimPrefix(b, w.Path+"."), time.Local)
+		if err == nil && !t.After(fi.ModTime()) {
+			return t
+		}
+	}
+	return fi.ModTime()
+}
+
+// rotate renames the current file and opens a new one.
+func (w *Writer) rotate() error {
+	if err := w.f.Close(); err != nil {
+		return err
+	}
+	w.f = nil
+	backup := w.Path + "." + time.Now().Format(timeFormat)
+	if err := os.Rename(w.Path, backup); err != nil {
+		return fmt.Errorf("rotate %s: %w", w.Path, err)
+	}
+	w.prune()
+	return w.open()
+}
+
+// prune removes the oldest rotated files beyond Backups.
+func (w *Writer) prune() {
+	if w.Backups <= 0 {
+		return
+	}
+	backups, err := filepath.Glob(w.Path + ".*")
+	if err != nil || len(backups) <= w.Backups {
+		return
+	}
+	slices.Sort(backups)
+	for _, b := range backups[:len(backups)-w.Backups] {
+		os.Remove(b)
+	}
+}
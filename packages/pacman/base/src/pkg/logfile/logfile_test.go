@@ -0,0 +1,118 @@
+package logfile
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// files returns the contents of the log and its rotated files, oldest first.
+func files(t *testing.T, path string) []string {
+	t.Helper()
+	backups, err := filepath.Glob(path + ".*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(backups)
+	var contents []string
+	for _, p := range append(backups, path) {
+		b, err := os.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents = append(contents, string(b))
+	}
+	return contents
+}
+
+func TestWriterRotateSize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", "access.log")
+	w := &Writer{Path: path, MaxSize: 8, Backups: 2}
+	defer w.Close()
+
+	for _, line := range []string{"one\n", "two\n", "three\n", "four\n", "five\n"} {
+		if _, err := w.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+		// rotated files are named by the millisecond
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	// the oldest rotated file is pruned
+	want := []string{"three\n", "four\n", "five\n"}
+	if got := files(t, path); !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestWriterRotateAge(t *testing.T) {
+	old := time.Now().Add(-2 * time.Hour)
+	tests := []struct {
+		name    string
+		setup   func(path string) error
+		rotated bool
+	}{
+		{
+			name: "recent",
+			setup: func(path string) error {
+				return os.WriteFile(path, []byte("old\n"), 0o600)
+			},
+		},
+		{
+			name: "old",
+			setup: func(path string) error {
+				if err := os.WriteFile(path, []byte("old\n"), 0o600); err != nil {
+					return err
+				}
+				return os.Chtimes(path, old, old)
+			},
+			rotated: true,
+		},
+		{
+			// written to recently, but started by a rotation long ago
+			name: "rotated long ago",
+			setup: func(path string) error {
+				if err := os.WriteFile(path+"."+old.Format(timeFormat), nil, 0o600); err != nil {
+					return err
+				}
+				return os.WriteFile(path, []byte("old\n"), 0o600)
+			},
+			rotated: true,
+		},
+		{
+			name: "rotated recently",
+			setup: func(path string) error {
+				if err := os.WriteFile(path+"."+old.Format(timeFormat), nil, 0o600); err != nil {
+					return err
+				}
+				recent := time.Now().Add(-time.Minute)
+				if err := os.WriteFile(path+"."+recent.Format(timeFormat), nil, 0o600); err != nil {
+					return err
+				}
+				return os.WriteFile(path, []byte("old\n"), 0o600)
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "access.log")
+			if err := tc.setup(path); err != nil {
+				t.Fatal(err)
+			}
+
+			// a restarted process appends to the existing file
+			w := &Writer{Path: path, MaxAge: time.Hour}
+			defer w.Close()
+			if _, err := w.Write([]byte("new\n")); err != nil {
+				t.Fatal(err)
+			}
+			got := files(t, path)
+			if current := got[len(got)-1]; (current == "new\n") != tc.rotated {
+				t.Errorf("got %q, want rotated %v", got, tc.rotated)
+			}
+		})
+	}
+}
@@ -0,0 +1,349 @@
+// Package metrics keeps counters, gauges and histograms and writes them
+// in the Prometheus text exposition format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// ContentType is the content type of the exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefBuckets are histogram buckets for durations in seconds.
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30}
+
+// Registry is a set of metrics.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+}
+
+type metric interface {
+	header() (name, help, typ string)
+	write(w *bufio.Writer)
+}
+
+func (r *Registry) register(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.metrics = append(r.metrics, m)
+}
+
+// Counter registers a counter with the given label names.
+func (r *Registry) Counter(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{vec: newVec[*Counter](name, help, "counter", labels)}
+	r.register(c)
+	return c
+}
+
+// Gauge registers a gauge with the given label names.
+func (r *Registry) Gauge(name, help string, labels ...string) *GaugeVec {
+	g := &GaugeVec{vec: newVec[*Gauge](name, help, "gauge", labels)}
+	r.register(g)
+	return g
+}
+
+// Histogram registers a histogram with the given upper bounds
+// of its buckets, in increasing order, and label names.
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{vec: newVec[*Histogram](name, help, "histogram", labels), buckets: buckets}
+	r.register(h)
+	return h
+}
+
+// GaugeFunc registers a gauge whose values are collected by fn when the
+// metrics are written. fn calls emit for each value with its label values.
+func (r *Registry) GaugeFunc(name, help string, labels []string, fn func(emit func(v float64, values ...string))) {
+	r.register(&gaugeFunc{name: name, help: help, labels: labels, fn: fn})
+}
+
+// WriteTo writes all metrics in the text exposition format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	metrics := slices.Clone(r.metrics)
+	r.mu.Unlock()
+
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, m := range metrics {
+		name, help, typ := m.header()
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s %s\n", name, escapeHelp(help), name, typ)
+		m.write(bw)
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// ServeHTTP writes the metrics.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", ContentType)
+	r.WriteTo(w)
+}
+
+// vec holds the series of a metric by their label values.
+type vec[S any] struct {
+	name, help, typ string
+	labels          []string
+
+	mu     sync.Mutex
+	series map[string]S
+	values map[string][]string
+}
+
+func newVec[S any](name, help, typ string, labels []string) *vec[S] {
+	return &vec[S]{
+		name:   name,
+		help:   help,
+		typ:    typ,
+		labels: labels,
+		series: make(map[string]S),
+		values: make(map[string][]string),
+	}
+}
+
+func (v *vec[S]) header() (string, string, string) {
+	return v.name, v.help, v.typ
+}
+
+func (v *vec[S]) with(values []string, new func() S) S {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s has %d labels, got %d values", v.name, len(v.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	s, ok := v.series[key]
+	if !ok {
+		s = new()
+		v.series[key] = s
+		v.values[key] = slices.Clone(values)
+	}
+	return s
+}
+
+// each calls fn for every series, ordered by label values.
+func (v *vec[S]) each(fn func(values []string, s S)) {
+	v.mu.Lock()
+	keys := make([]string, 0, len(v.series))
+	for k := range v.series {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	series := make([]S, len(keys))
+	values := make([][]string, len(keys))
+	for i, k := range keys {
+		series[i], values[i] = v.series[k], v.values[k]
+	}
+	v.mu.Unlock()
+
+	for i := range keys {
+		fn(values[i], series[i])
+	}
+}
+
+// value is a float64 that can be updated atomically.
+type value struct {
+	bits atomic.Uint64
+}
+
+func (v *value) add(d float64) {
+	for {
+		old := v.bits.Load()
+		if v.bits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+d)) {
+			return
+		}
+	}
+}
+
+func (v *value) load() float64 {
+	return math.Float64frombits(v.bits.Load())
+}
+
+// CounterVec is a counter partitioned by labels.
+type CounterVec struct {
+	*vec[*Counter]
+}
+
+// With returns the counter of the label values.
+func (c *CounterVec) With(values ...string) *Counter {
+	return c.with(values, func() *Counter { return new(Counter) })
+}
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	c.each(func(values []string, s *Counter) {
+		writeSample(w, c.name, c.labels, values, s.v.load())
+	})
+}
+
+// Counter is a value that only goes up.
+type Counter struct {
+	v value
+}
+
+func (c *Counter) Inc() {
+	c.v.add(1)
+}
+
+// Add adds d, which must not be negative.
+func (c *Counter) Add(d float64) {
+	c.v.add(d)
+}
+
+// GaugeVec is a gauge partitioned by labels.
+type GaugeVec struct {
+	*vec[*Gauge]
+}
+
+// With returns the gauge of the label values.
+func (g *GaugeVec) With(values ...string) *Gauge {
+	return g.with(values, func() *Gauge { return new(Gauge) })
+}
+
+func (g *GaugeVec) write(w *bufio.Writer) {
+	g.each(func(values []string, s *Gauge) {
+		writeSample(w, g.name, g.labels, values, s.v.load())
+	})
+}
+
+// Gauge is a value that goes up and down.
+type Gauge struct {
+	v value
+}
+
+func (g *Gauge) Inc() {
+	g.v.add(1)
+}
+
+func (g *Gauge) Dec() {
+	g.v.add(-1)
+}
+
+func (g *Gauge) Add(d float64) {
+	g.v.add(d)
+}
+
+func (g *Gauge) Set(v float64) {
+	g.v.bits.Store(math.Float64bits(v))
+}
+
+// HistogramVec is a histogram partitioned by labels.
+type HistogramVec struct {
+	*vec[*Histogram]
+	buckets []float64
+}
+
+// With returns the histogram of the label values.
+func (h *HistogramVec) With(values ...string) *Histogram {
+	return h.with(values, func() *Histogram {
+		return &Histogram{upper: h.buckets, counts: make([]atomic.Uint64, len(h.buckets))}
+	})
+}
+
+func (h *HistogramVec) write(w *bufio.Writer) {
+	labels := append(slices.Clone(h.labels), "le")
+	h.each(func(values []string, s *Histogram) {
+		values = append(slices.Clone(values), "")
+		var cumulative uint64
+		for i, upper := range s.upper {
+			cumulative += s.counts[i].Load()
+			values[len(values)-1] = formatFloat(upper)
+			writeSample(w, h.name+"_bucket", labels, values, float64(cumulative))
+		}
+		count := s.count.Load()
+		values[len(values)-1] = "+Inf"
+		writeSample(w, h.name+"_bucket", labels, values, float64(count))
+		writeSample(w, h.name+"_sum", h.labels, values[:len(values)-1], s.sum.load())
+		writeSample(w, h.name+"_count", h.labels, values[:len(values)-1], float64(count))
+	})
+}
+
+// Histogram counts observations in buckets.
+type Histogram struct {
+	upper  []float64
+	counts []atomic.Uint64 // observations in each bucket, not cumulative
+	count  atomic.Uint64
+	sum    value
+}
+
+func (h *Histogram) Observe(v float64) {
+	if i, _ := slices.BinarySearch(h.upper, v); i < len(h.counts) {
+		h.counts[i].Add(1)
+	}
+	h.count.Add(1)
+	h.sum.add(v)
+}
+
+type gaugeFunc struct {
+	name, help string
+	labels     []string
+	fn         func(emit func(v float64, values ...string))
+}
+
+func (g *gaugeFunc) header() (string, string, string) {
+	return g.name, g.help, "gauge"
+}
+
+func (g *gaugeFunc) write(w *bufio.Writer) {
+	g.fn(func(v float64, values ...string) {
+		writeSample(w, g.name, g.labels, values, v)
+	})
+}
+
+func writeSample(w *bufio.Writer, name string, labels, values []string, v float64) {
+	w.WriteString(name)
+	if len(labels) > 0 {
+		w.WriteByte('{')
+		for i, l := range labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			w.WriteString(l)
+			w.WriteString(`="`)
+			w.WriteString(labelEscaper.Replace(values[i]))
+			w.WriteByte('"')
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(v))
+	w.WriteByte('\n')
+}
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -2,9 +2,25 @@ package netutil
 
 import (
 	"bufio"
+	"context"
+	"io"
 	"net"
 )
 
+type clientKey struct{}
+
+// WithClient returns a context carrying the address of the client
+// a connection is dialed for.
+func WithClient(ctx context.Context, addr string) context.Context {
+	return context.WithValue(ctx, clientKey{}, addr)
+}
+
+// Client returns the client address carried by ctx, if any.
+func Client(ctx context.Context) string {
+	addr, _ := ctx.Value(clientKey{}).(string)
+	return addr
+}
+
 var _ net.Conn = (*BuffConn)(nil)
 
 // BuffConn is a net.Conn wrapper with buffered reads and writes.
@@ -37,3 +53,12 @@ func (b *BuffConn) Write(p []byte) (int, error) {
 	}
 	return n, err
 }
+
+// ReadFrom flushes the buffered writer and copies from r straight to the
+// connection, so that nothing copied is held in the buffer.
+func (b *BuffConn) ReadFrom(r io.Reader) (int64, error) {
+	if err := b.Flush(); err != nil {
+		return 0, err
+	}
+	return io.Copy(b.Conn, r)
+}
@@ -2,8 +2,13 @@ package netutil
 
 import (
 	"encoding"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
+	"net/netip"
+	"strconv"
+	"strings"
 )
 
 // HostPort stores a validated host:port string
@@ -30,3 +35,114 @@ func (hp *HostPort) String() string {
 	}
 	return string(*hp)
 }
+
+// PortRange is an inclusive range of ports.
+// It unmarshals from a port number or a "first-last" string.
+type PortRange struct {
+	First, Last uint16
+}
+
+var _ json.Unmarshaler = (*PortRange)(nil)
+
+func (r *PortRange) UnmarshalJSON(data []byte) error {
+	text := strings.Trim(string(data), `"`)
+	first, last, found := strings.Cut(text, "-")
+	if !found {
+		last = first
+	}
+	a, err := strconv.ParseUint(first, 10, 16)
+	if err != nil {
+		return fmt.Errorf("invalid port range %q", text)
+	}
+	b, err := strconv.ParseUint(last, 10, 16)
+	if err != nil || b < a || a == 0 {
+		return fmt.Errorf("invalid port range %q", text)
+	}
+	r.First, r.Last = uint16(a), uint16(b)
+	return nil
+}
+
+// Contains reports whether port is in the range.
+func (r PortRange) Contains(port int) bool {
+	return port >= int(r.First) && port <= int(r.Last)
+}
+
+func (r PortRange) String() string {
+	if r.First == r.Last {
+		return strconv.Itoa(int(r.First))
+	}
+	return fmt.Sprintf("%d-%d", r.First, r.Last)
+}
+
+// Network is a transport protocol, either "tcp" or "udp".
+type Network string
+
+var _ encoding.TextUnmarshaler = (*Network)(nil)
+
+func (n *Network) UnmarshalText(text []byte) error {
+	switch s := strings.ToLower(string(text)); s {
+	case "tcp", "udp":
+		*n = Network(s)
+		return nil
+	}
+	return fmt.Errorf("invalid network %q, expected tcp or udp", text)
+}
+
+// Match reports whether a network as passed to Dial, such as "tcp4",
+// uses the protocol.
+func (n Network) Match(network string) bool {
+	return strings.TrimRight(network, "46") == string(n)
+}
+
+// ListenAddr is an address to listen on, either host:port for TCP
+// or unix:/path for a Unix socket.
+type ListenAddr struct {
+	Network string
+	Address string
+}
+
+var _ encoding.TextUnmarshaler = (*ListenAddr)(nil)
+
+func (a *ListenAddr) UnmarshalText(text []byte) error {
+	if path, ok := strings.CutPrefix(string(text), "unix:"); ok {
+		if path == "" {
+			return errors.New("invalid unix socket address: empty path")
+		}
+		*a = ListenAddr{Network: "unix", Address: path}
+		return nil
+	}
+	var hp HostPort
+	if err := hp.UnmarshalText(text); err != nil {
+		return err
+	}
+	*a = ListenAddr{Network: "tcp", Address: string(hp)}
+	return nil
+}
+
+func (a ListenAddr) String() string {
+	if a.Network == "unix" {
+		return "unix:" + a.Address
+	}
+	return a.Address
+}
+
+// Prefix is an IP network in CIDR notation. A single
+// address is a network of only that address.
+type Prefix struct {
+	netip.Prefix
+}
+
+var _ encoding.TextUnmarshaler = (*Prefix)(nil)
+
+func (p *Prefix) UnmarshalText(text []byte) error {
+	if addr, err := netip.ParseAddr(string(text)); err == nil {
+		p.Prefix = netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen())
+		return nil
+	}
+	prefix, err := netip.ParsePrefix(string(text))
+	if err != nil {
+		return fmt.Errorf("invalid network %q, expected an address or CIDR", text)
+	}
+	p.Prefix = prefix.Masked()
+	return nil
+}
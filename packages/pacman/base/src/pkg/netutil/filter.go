@@ -0,0 +1,57 @@
+package netutil
+
+import (
+	"net"
+	"net/netip"
+)
+
+// Loopback are the networks of the loopback addresses.
+var Loopback = []Prefix{
+	{netip.MustParsePrefix("127.0.0.0/8")},
+	{netip.MustParsePrefix("::1/128")},
+}
+
+// ClientFilter allows or denies clients by their IP address.
+type ClientFilter struct {
+	Allow []Prefix // only clients in these networks, any client if empty
+	Deny  []Prefix // never clients in these networks, even if allowed
+}
+
+// Allowed reports whether the client at addr may connect. Clients
+// without an IP address, such as those of a Unix socket, are allowed.
+func (f *ClientFilter) Allowed(addr net.Addr) bool {
+	ip, ok := addrIP(addr)
+	if !ok {
+		return true
+	}
+	if contains(f.Deny, ip) {
+		return false
+	}
+	return len(f.Allow) == 0 || contains(f.Allow, ip)
+}
+
+func contains(prefixes []Prefix, ip netip.Addr) bool {
+	for _, p := range prefixes {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// addrIP returns the IP address of addr, with IPv4-mapped
+// IPv6 addresses as IPv4.
+func addrIP(addr net.Addr) (netip.Addr, bool) {
+	if addr == nil {
+		return netip.Addr{}, false
+	}
+	if a, ok := addr.(*net.TCPAddr); ok {
+		ip, ok := netip.AddrFromSlice(a.IP)
+		return ip.Unmap(), ok
+	}
+	ap, err := netip.ParseAddrPort(addr.String())
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return ap.Addr().Unmap(), true
+}
@@ -53,6 +53,9 @@ type muxEntry struct {
 
 // ListenMux wraps a net.Listener and dispatches connections based on match functions.
 type ListenMux struct {
+	// Allow, if set, is called with each connection before its
+	// protocol is sniffed. Connections it rejects are closed.
+	Allow   func(net.Conn) bool
 	entries []*muxEntry
 }
 
@@ -70,6 +73,10 @@ func (m *ListenMux) Listener(match func(*BuffConn) bool) net.Listener {
 }
 
 func (m *ListenMux) ServeConn(conn net.Conn) {
+	if m.Allow != nil && !m.Allow(conn) {
+		conn.Close()
+		return
+	}
 	bc := NewBuffConn(conn)
 	for _, e := range m.entries {
 		if e.match(bc) {
@@ -114,6 +121,12 @@ func (s *MuxServer) HandleServer(match func(*BuffConn) bool, srv Server) {
 	})
 }
 
+// Allow sets the check of the connections, see ListenMux.Allow.
+// It must be called before Serve.
+func (s *MuxServer) Allow(allow func(net.Conn) bool) {
+	s.mux.Allow = allow
+}
+
 func (s *MuxServer) Serve(l net.Listener) error {
 	s.g.Go(func() error {
 		return Serve(l, s.mux)
@@ -129,6 +142,15 @@ func SOCKS5Match(conn *BuffConn) bool {
 	return magic[0] == 0x05
 }
 
+// SOCKS4Match matches SOCKS4 and SOCKS4a CONNECT and BIND requests.
+func SOCKS4Match(conn *BuffConn) bool {
+	magic, err := conn.Peek(2)
+	if err != nil {
+		return false
+	}
+	return magic[0] == 0x04 && (magic[1] == 0x01 || magic[1] == 0x02)
+}
+
 func SSHMatch(conn *BuffConn) bool {
 	magic, err := conn.Peek(4)
 	if err != nil {
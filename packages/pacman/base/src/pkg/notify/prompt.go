@@ -0,0 +1,92 @@
+//go:build darwin
+
+package notify
+
+import (
+	"context"
+
+	"github.com/gilliginsisland/pacman/pkg/menuet"
+	"github.com/gilliginsisland/pacman/pkg/prompt"
+)
+
+// Categories must be registered with the application for Prompter to work.
+var Categories = []menuet.NotificationCategory{
+	{
+		Identifier: "prompt-input",
+		Actions: []menuet.Actioner{
+			menuet.NotificationActionText{
+				NotificationAction: menuet.NotificationAction{
+					Identifier: "prompt-input-text",
+					Title:      "Reply",
+				},
+				TextInputButtonTitle: "Submit",
+			},
+		},
+		Options: menuet.CategoryOptionCustomDismiss,
+	},
+	{
+		Identifier: "prompt-confirm",
+		Actions: []menuet.Actioner{
+			menuet.NotificationAction{
+				Identifier: "prompt-confirm-open",
+				Title:      "Open",
+			},
+		},
+		Options: menuet.CategoryOptionCustomDismiss,
+	},
+}
+
+var _ prompt.Prompter = Prompter{}
+
+// Prompter asks for input with time sensitive notifications.
+// Clicking an input notification instead of replying to it opens an alert.
+type Prompter struct{}
+
+func (Prompter) Confirm(ctx context.Context, req prompt.Request) error {
+	resp, err := NotifyCtx(ctx, Notification{
+		CategoryIdentifier: "prompt-confirm",
+		Title:              req.Title,
+		Subtitle:           req.Subtitle,
+		Body:               req.Message,
+		InterruptionLevel:  menuet.NotificationInterruptionLevelTimeSensitive,
+	})
+	if err != nil {
+		return err
+	}
+	if resp.ActionIdentifier == menuet.DismissActionIdentifier {
+		return prompt.ErrCancelled
+	}
+	return nil
+}
+
+func (Prompter) Input(ctx context.Context, req prompt.Request) (string, error) {
+	resp, err := NotifyCtx(ctx, Notification{
+		CategoryIdentifier: "prompt-input",
+		Title:              req.Title,
+		Subtitle:           req.Subtitle,
+		Body:               req.Message,
+		InterruptionLevel:  menuet.NotificationInterruptionLevelTimeSensitive,
+	})
+	if err != nil {
+		return "", err
+	}
+	switch resp.ActionIdentifier {
+	case "prompt-input-text":
+		return resp.Text, nil
+	case menuet.DefaultActionIdentifier:
+		resp, err := menuet.DisplayCtx(ctx, menuet.Alert{
+			MessageText:     req.Subtitle,
+			InformativeText: req.Message,
+			Inputs:          []string{req.Placeholder},
+			Buttons:         []string{"Submit", "Cancel"},
+		})
+		if err != nil {
+			return "", err
+		}
+		if resp.Button == 1 {
+			return "", prompt.ErrCancelled
+		}
+		return resp.Inputs[0], nil
+	}
+	return "", prompt.ErrCancelled
+}
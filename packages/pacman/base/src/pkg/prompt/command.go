@@ -0,0 +1,67 @@
+package prompt
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+var _ Prompter = (*Command)(nil)
+
+// Command is a Prompter that delegates to an external program, in the
+// style of SSH_ASKPASS.
+//
+// The program is invoked with the request message as its only argument.
+// The remaining fields of the request are passed in the environment as
+// PACMAN_PROMPT_TITLE, PACMAN_PROMPT_SUBTITLE and PACMAN_PROMPT_PLACEHOLDER,
+// and PACMAN_PROMPT_KIND is set to "confirm" or "input".
+// A zero exit status accepts the request, and for Input the first line
+// written to stdout is the answer.
+type Command struct {
+	Path string
+}
+
+func (c *Command) Confirm(ctx context.Context, req Request) error {
+	_, err := c.run(ctx, "confirm", req)
+	return err
+}
+
+func (c *Command) Input(ctx context.Context, req Request) (string, error) {
+	out, err := c.run(ctx, "input", req)
+	if err != nil {
+		return "", err
+	}
+	line, _, _ := strings.Cut(out, "\n")
+	return strings.TrimSuffix(line, "\r"), nil
+}
+
+func (c *Command) run(ctx context.Context, kind string, req Request) (string, error) {
+	var stdout, stderr bytes.Buffer
+
+	cmd := exec.CommandContext(ctx, c.Path, req.Message)
+	cmd.Env = append(os.Environ(),
+		"PACMAN_PROMPT_KIND="+kind,
+		"PACMAN_PROMPT_TITLE="+req.Title,
+		"PACMAN_PROMPT_SUBTITLE="+req.Subtitle,
+		"PACMAN_PROMPT_PLACEHOLDER="+req.Placeholder,
+	)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if err == nil {
+		return stdout.String(), nil
+	}
+	if ctx.Err() != nil {
+		return "", ctx.Err()
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return "", ErrCancelled
+	}
+	return "", fmt.Errorf("prompt command failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+}
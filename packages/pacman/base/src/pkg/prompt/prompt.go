@@ -0,0 +1,73 @@
+// Package prompt asks the user for input on behalf of a proxy dialer.
+//
+// Dialers that need interactive authentication (browser based SSO, OTP
+// tokens) go through the Default prompter. The menu bar app installs a
+// notification based prompter, headless daemons can plug in any other
+// backend with SetDefault.
+package prompt
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync/atomic"
+)
+
+var (
+	ErrCancelled   = errors.New("prompt cancelled by user")
+	ErrUnavailable = errors.New("no interactive prompt available")
+)
+
+// Request describes what is asked of the user.
+type Request struct {
+	Title       string // usually the proxy label
+	Subtitle    string
+	Message     string
+	Placeholder string // only used by Input
+}
+
+// Prompter asks the user to confirm an action or to enter a value.
+type Prompter interface {
+	// Confirm blocks until the user accepts or declines the request.
+	// A declined request returns ErrCancelled.
+	Confirm(ctx context.Context, req Request) error
+	// Input blocks until the user enters a value.
+	Input(ctx context.Context, req Request) (string, error)
+}
+
+var std atomic.Pointer[Prompter]
+
+// Default returns the prompter installed with SetDefault.
+// If none was installed, a None prompter is returned.
+func Default() Prompter {
+	if p := std.Load(); p != nil {
+		return *p
+	}
+	return None{}
+}
+
+// SetDefault installs p as the prompter returned by Default.
+func SetDefault(p Prompter) {
+	std.Store(&p)
+}
+
+// None is a Prompter that refuses every request.
+type None struct{}
+
+func (None) Confirm(ctx context.Context, req Request) error {
+	log(ctx, req)
+	return ErrUnavailable
+}
+
+func (None) Input(ctx context.Context, req Request) (string, error) {
+	log(ctx, req)
+	return "", ErrUnavailable
+}
+
+func log(ctx context.Context, req Request) {
+	slog.WarnContext(ctx, "prompt requested without an interactive backend",
+		slog.String("title", req.Title),
+		slog.String("subtitle", req.Subtitle),
+		slog.String("message", req.Message),
+	)
+}
@@ -0,0 +1,86 @@
+// Package secret resolves credentials that are kept out of the config file.
+package secret
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+var ErrNotFound = errors.New("secret not found")
+
+// Source looks up a secret when it is needed.
+// String describes where the secret comes from without revealing it.
+type Source interface {
+	Secret(ctx context.Context) (string, error)
+	String() string
+}
+
+var (
+	_ Source = Command("")
+	_ Source = File("")
+	_ Source = Env("")
+	_ Source = Service(nil)
+)
+
+// Command runs a shell command and uses its output, without the trailing
+// newline, as the secret.
+type Command string
+
+func (c Command) Secret(ctx context.Context) (string, error) {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", string(c))
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("secret command failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimRight(string(out), "\r\n"), nil
+}
+
+func (c Command) String() string {
+	return "command:" + string(c)
+}
+
+// File reads the secret from a file. A leading "~/" is expanded to the
+// home directory.
+type File string
+
+func (f File) Secret(ctx context.Context) (string, error) {
+	p := string(f)
+	if s, found := strings.CutPrefix(p, "~/"); found {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		p = filepath.Join(home, s)
+	}
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return "", fmt.Errorf("secret file: %w", err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+func (f File) String() string {
+	return "file:" + string(f)
+}
+
+// Env reads the secret from an environment variable.
+type Env string
+
+func (e Env) Secret(ctx context.Context) (string, error) {
+	if v, ok := os.LookupEnv(string(e)); ok {
+		return v, nil
+	}
+	return "", fmt.Errorf("%w: environment variable %s is not set", ErrNotFound, string(e))
+}
+
+func (e Env) String() string {
+	return "env:" + string(e)
+}
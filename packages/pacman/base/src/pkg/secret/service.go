@@ -0,0 +1,145 @@
+package secret
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// names of the freedesktop Secret Service API
+const (
+	secretsName    = "org.freedesktop.secrets"
+	secretsPath    = dbus.ObjectPath("/org/freedesktop/secrets")
+	serviceIface   = "org.freedesktop.Secret.Service"
+	sessionIface   = "org.freedesktop.Secret.Session"
+	itemIface      = "org.freedesktop.Secret.Item"
+	promptIface    = "org.freedesktop.Secret.Prompt"
+	noPrompt       = dbus.ObjectPath("/")
+	plainAlgorithm = "plain"
+)
+
+// Service looks up the secret with the given attributes in the
+// freedesktop Secret Service (GNOME Keyring, KWallet) on the D-Bus
+// session bus. A locked item is unlocked first, which may prompt the
+// user for the password of its collection.
+type Service map[string]string
+
+// serviceSecret is the Secret struct of the Secret Service API.
+type serviceSecret struct {
+	Session     dbus.ObjectPath
+	Parameters  []byte
+	Value       []byte
+	ContentType string
+}
+
+func (s Service) Secret(ctx context.Context) (string, error) {
+	conn, err := dbus.ConnectSessionBus(dbus.WithContext(ctx))
+	if err != nil {
+		return "", fmt.Errorf("secret service: failed to connect to the D-Bus session bus: %w", err)
+	}
+	defer conn.Close()
+	svc := conn.Object(secretsName, secretsPath)
+
+	// the secret is not encrypted, the session bus
+	// only carries messages between processes of the user
+	var (
+		output  dbus.Variant
+		session dbus.ObjectPath
+	)
+	err = svc.CallWithContext(ctx, serviceIface+".OpenSession", 0, plainAlgorithm, dbus.MakeVariant("")).Store(&output, &session)
+	if err != nil {
+		return "", fmt.Errorf("secret service: failed to open a session: %w", err)
+	}
+	defer conn.Object(secretsName, session).CallWithContext(ctx, sessionIface+".Close", 0)
+
+	var unlocked, locked []dbus.ObjectPath
+	err = svc.CallWithContext(ctx, serviceIface+".SearchItems", 0, map[string]string(s)).Store(&unlocked, &locked)
+	if err != nil {
+		return "", fmt.Errorf("secret service: search failed: %w", err)
+	}
+	if len(unlocked) == 0 && len(locked) > 0 {
+		if unlocked, err = unlock(ctx, conn, locked[:1]); err != nil {
+			return "", err
+		}
+	}
+	if len(unlocked) == 0 {
+		return "", fmt.Errorf("%w: no secret service item matches %s", ErrNotFound, s)
+	}
+
+	var secret serviceSecret
+	err = conn.Object(secretsName, unlocked[0]).CallWithContext(ctx, itemIface+".GetSecret", 0, session).Store(&secret)
+	if err != nil {
+		return "", fmt.Errorf("secret service: failed to get the secret of %s: %w", unlocked[0], err)
+	}
+	return string(secret.Value), nil
+}
+
+// unlock unlocks the items, waiting for the user to
+// complete the prompt if the service shows one.
+func unlock(ctx context.Context, conn *dbus.Conn, items []dbus.ObjectPath) ([]dbus.ObjectPath, error) {
+	var (
+		unlocked []dbus.ObjectPath
+		prompt   dbus.ObjectPath
+	)
+	err := conn.Object(secretsName, secretsPath).CallWithContext(ctx, serviceIface+".Unlock", 0, items).Store(&unlocked, &prompt)
+	if err != nil {
+		return nil, fmt.Errorf("secret service: unlock failed: %w", err)
+	}
+	if prompt == noPrompt {
+		return unlocked, nil
+	}
+
+	signals := make(chan *dbus.Signal, 1)
+	conn.Signal(signals)
+	defer conn.RemoveSignal(signals)
+	err = conn.AddMatchSignalContext(ctx,
+		dbus.WithMatchObjectPath(prompt),
+		dbus.WithMatchInterface(promptIface),
+		dbus.WithMatchMember("Completed"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("secret service: unlock failed: %w", err)
+	}
+	if err := conn.Object(secretsName, prompt).CallWithContext(ctx, promptIface+".Prompt", 0, "").Err; err != nil {
+		return nil, fmt.Errorf("secret service: unlock prompt failed: %w", err)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case sig, ok := <-signals:
+			if !ok {
+				return nil, errors.New("secret service: disconnected from the session bus")
+			}
+			if sig.Path != prompt || sig.Name != promptIface+".Completed" {
+				continue
+			}
+			var (
+				dismissed bool
+				result    dbus.Variant
+			)
+			if err := dbus.Store(sig.Body, &dismissed, &result); err != nil {
+				return nil, fmt.Errorf("secret service: unlock failed: %w", err)
+			}
+			if dismissed {
+				return nil, errors.New("secret service: unlock was dismissed")
+			}
+			unlocked, _ := result.Value().([]dbus.ObjectPath)
+			return unlocked, nil
+		}
+	}
+}
+
+func (s Service) String() string {
+	attrs := make([]string, 0, len(s))
+	for k, v := range s {
+		attrs = append(attrs, k+"="+v)
+	}
+	slices.Sort(attrs)
+	return "secret-service:" + strings.Join(attrs, ",")
+}
@@ -0,0 +1,168 @@
+package secret
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"maps"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// sessionBus starts a private session bus for the test.
+func sessionBus(t *testing.T) {
+	t.Helper()
+	daemon, err := exec.LookPath("dbus-daemon")
+	if err != nil {
+		t.Skip("dbus-daemon is needed for a session bus")
+	}
+	cmd := exec.Command(daemon, "--session", "--nofork", "--print-address")
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+	addr, err := bufio.NewReader(out).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("DBUS_SESSION_BUS_ADDRESS", strings.TrimSpace(addr))
+}
+
+type fakeItem struct {
+	attrs   map[string]string
+	value   string
+	locked  bool
+	dismiss bool // dismiss the unlock prompt
+}
+
+// serveSecrets serves the items as the Secret Service on the session bus.
+func serveSecrets(t *testing.T, items map[dbus.ObjectPath]*fakeItem) {
+	t.Helper()
+	conn, err := dbus.ConnectSessionBus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	const session = dbus.ObjectPath("/org/freedesktop/secrets/session/1")
+	export := func(methods map[string]any, path dbus.ObjectPath, iface string) {
+		if err := conn.ExportMethodTable(methods, path, iface); err != nil {
+			t.Fatal(err)
+		}
+	}
+	export(map[string]any{
+		"OpenSession": func(algorithm string, input dbus.Variant) (dbus.Variant, dbus.ObjectPath, *dbus.Error) {
+			if algorithm != plainAlgorithm {
+				return dbus.Variant{}, "", dbus.MakeFailedError(errors.New("unsupported algorithm"))
+			}
+			return dbus.MakeVariant(""), session, nil
+		},
+		"SearchItems": func(attrs map[string]string) ([]dbus.ObjectPath, []dbus.ObjectPath, *dbus.Error) {
+			var unlocked, locked []dbus.ObjectPath
+			for path, item := range items {
+				switch {
+				case !maps.Equal(item.attrs, attrs):
+				case item.locked:
+					locked = append(locked, path)
+				default:
+					unlocked = append(unlocked, path)
+				}
+			}
+			return unlocked, locked, nil
+		},
+		"Unlock": func(objects []dbus.ObjectPath) ([]dbus.ObjectPath, dbus.ObjectPath, *dbus.Error) {
+			prompt := dbus.ObjectPath("/org/freedesktop/secrets/prompt/1")
+			export(map[string]any{
+				"Prompt": func(windowID string) *dbus.Error {
+					go func() {
+						item := items[objects[0]]
+						item.locked = item.dismiss
+						conn.Emit(prompt, promptIface+".Completed", item.dismiss, dbus.MakeVariant(objects))
+					}()
+					return nil
+				},
+			}, prompt, promptIface)
+			return nil, prompt, nil
+		},
+	}, secretsPath, serviceIface)
+	export(map[string]any{
+		"Close": func() *dbus.Error { return nil },
+	}, session, sessionIface)
+	for path, item := range items {
+		export(map[string]any{
+			"GetSecret": func(s dbus.ObjectPath) (serviceSecret, *dbus.Error) {
+				if s != session || item.locked {
+					return serviceSecret{}, dbus.MakeFailedError(errors.New("no access"))
+				}
+				return serviceSecret{Session: s, Value: []byte(item.value), ContentType: "text/plain"}, nil
+			},
+		}, path, itemIface)
+	}
+
+	if _, err := conn.RequestName(secretsName, dbus.NameFlagDoNotQueue); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestService(t *testing.T) {
+	sessionBus(t)
+	serveSecrets(t, map[dbus.ObjectPath]*fakeItem{
+		"/org/freedesktop/secrets/collection/login/1": {
+			attrs: map[string]string{"service": "vpn", "user": "alice"},
+			value: "hunter2",
+		},
+		"/org/freedesktop/secrets/collection/login/2": {
+			attrs:  map[string]string{"service": "vpn", "user": "bob"},
+			value:  "correct horse",
+			locked: true,
+		},
+		"/org/freedesktop/secrets/collection/login/3": {
+			attrs:   map[string]string{"service": "vpn", "user": "carol"},
+			value:   "unreachable",
+			locked:  true,
+			dismiss: true,
+		},
+	})
+
+	tests := []struct {
+		user   string
+		expect string
+		err    string
+	}{
+		{user: "alice", expect: "hunter2"},
+		{user: "bob", expect: "correct horse"}, // unlocked by the prompt
+		{user: "carol", err: "unlock was dismissed"},
+		{user: "dave", err: "secret not found: no secret service item matches secret-service:service=vpn,user=dave"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.user, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			got, err := Service{"service": "vpn", "user": tc.user}.Secret(ctx)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("got %q, %v, want error %q", got, err, tc.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tc.expect {
+				t.Errorf("got %q, want %q", got, tc.expect)
+			}
+		})
+	}
+}
@@ -0,0 +1,415 @@
+package socksproxy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/netip"
+	"os"
+	"slices"
+	"strconv"
+	"syscall"
+
+	"github.com/gilliginsisland/pacman/pkg/dialer"
+	"github.com/gilliginsisland/pacman/pkg/netutil"
+)
+
+const socks5Version = 5
+
+// authentication methods
+const (
+	noAuthRequired   byte = 0
+	userPassAuth     byte = 2
+	noAcceptableAuth byte = 0xff
+)
+
+// username/password authentication, RFC 1929
+const (
+	userPassVersion byte = 1
+	userPassSuccess byte = 0
+	userPassFailure byte = 1
+)
+
+// commands
+const (
+	cmdConnect      byte = 1
+	cmdUDPAssociate byte = 3
+)
+
+// address types
+const (
+	atypIPv4   byte = 1
+	atypDomain byte = 3
+	atypIPv6   byte = 4
+)
+
+// ErrAuthFailed is returned for clients that log in with
+// an invalid username or password.
+var ErrAuthFailed = errors.New("authentication failed")
+
+// Reply is a SOCKS5 reply code as defined in RFC 1928.
+type Reply byte
+
+const (
+	Succeeded               Reply = 0
+	GeneralFailure          Reply = 1
+	NotAllowedByRuleset     Reply = 2
+	NetworkUnreachable      Reply = 3
+	HostUnreachable         Reply = 4
+	ConnectionRefused       Reply = 5
+	TTLExpired              Reply = 6
+	CommandNotSupported     Reply = 7
+	AddressTypeNotSupported Reply = 8
+)
+
+// ReplyFor maps a dial error to the reply reported to the client.
+func ReplyFor(err error) Reply {
+	var dnsErr *net.DNSError
+	switch {
+	case err == nil:
+		return Succeeded
+	case errors.Is(err, dialer.ErrRejected):
+		return NotAllowedByRuleset
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return ConnectionRefused
+	case errors.Is(err, syscall.ENETUNREACH):
+		return NetworkUnreachable
+	case errors.Is(err, syscall.EHOSTUNREACH), errors.As(err, &dnsErr):
+		return HostUnreachable
+	case errors.Is(err, os.ErrDeadlineExceeded), errors.Is(err, context.DeadlineExceeded):
+		return TTLExpired
+	default:
+		return GeneralFailure
+	}
+}
+
+// Server is a SOCKS5 proxy server supporting the CONNECT and
+// UDP ASSOCIATE commands.
+type Server struct {
+	Dialer func(ctx context.Context, network, address string) (net.Conn, error)
+	// Authenticate, if set, requires clients to log in with a username
+	// and password, and reports whether they are valid.
+	Authenticate func(username, password string) bool
+}
+
+// Serve accepts connections on the listener and serves them.
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go s.handleConn(conn)
+	}
+}
+
+func (s *Server) handleConn(conn net.Conn) {
+	defer conn.Close()
+
+	br := bufio.NewReader(conn)
+	cmd, address, err := s.handshake(br, conn)
+	if errors.Is(err, ErrAuthFailed) {
+		slog.Warn("socks client rejected", slog.String("client", conn.RemoteAddr().String()), slog.Any("error", err))
+		return
+	}
+	if err != nil {
+		slog.Debug("socks handshake failed", slog.Any("error", err))
+		return
+	}
+
+	ctx, cancel := context.WithCancel(netutil.WithClient(context.Background(), conn.RemoteAddr().String()))
+	defer cancel()
+
+	switch cmd {
+	case cmdConnect:
+		err = s.connect(ctx, &netutil.BuffConn{
+			Conn:       conn,
+			ReadWriter: bufio.NewReadWriter(br, bufio.NewWriter(conn)),
+		}, address)
+	case cmdUDPAssociate:
+		err = s.associate(ctx, conn, br)
+	default:
+		writeReply(conn, CommandNotSupported, nil)
+		err = fmt.Errorf("unsupported command %d", cmd)
+	}
+	if err != nil {
+		slog.Error("socks request failed",
+			slog.String("address", address),
+			slog.Any("error", err),
+		)
+	}
+}
+
+// handshake negotiates the authentication method and reads the request.
+func (s *Server) handshake(r *bufio.Reader, w io.Writer) (byte, string, error) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return 0, "", err
+	}
+	if hdr[0] != socks5Version {
+		return 0, "", fmt.Errorf("unsupported socks version %d", hdr[0])
+	}
+	methods := make([]byte, hdr[1])
+	if _, err := io.ReadFull(r, methods); err != nil {
+		return 0, "", err
+	}
+	want := noAuthRequired
+	if s.Authenticate != nil {
+		want = userPassAuth
+	}
+	method := noAcceptableAuth
+	if slices.Contains(methods, want) {
+		method = want
+	}
+	if _, err := w.Write([]byte{socks5Version, method}); err != nil {
+		return 0, "", err
+	}
+	switch method {
+	case noAcceptableAuth:
+		return 0, "", errors.New("no acceptable authentication method")
+	case userPassAuth:
+		if err := s.authenticate(r, w); err != nil {
+			return 0, "", err
+		}
+	}
+
+	var req [3]byte
+	if _, err := io.ReadFull(r, req[:]); err != nil {
+		return 0, "", err
+	}
+	if req[0] != socks5Version {
+		return 0, "", fmt.Errorf("unsupported socks version %d", req[0])
+	}
+	address, err := readAddr(r)
+	if err != nil {
+		writeReply(w, AddressTypeNotSupported, nil)
+		return 0, "", err
+	}
+	return req[1], address, nil
+}
+
+// authenticate reads the username and password of the client
+// and replies whether they are valid.
+func (s *Server) authenticate(r *bufio.Reader, w io.Writer) error {
+	ver, err := r.ReadByte()
+	if err != nil {
+		return err
+	}
+	if ver != userPassVersion {
+		return fmt.Errorf("unsupported username/password auth version %d", ver)
+	}
+	username, err := readString(r)
+	if err != nil {
+		return err
+	}
+	password, err := readString(r)
+	if err != nil {
+		return err
+	}
+	if !s.Authenticate(username, password) {
+		w.Write([]byte{userPassVersion, userPassFailure})
+		return fmt.Errorf("%w for user %q", ErrAuthFailed, username)
+	}
+	_, err = w.Write([]byte{userPassVersion, userPassSuccess})
+	return err
+}
+
+// readString reads a string prefixed by its length in a byte.
+func readString(r io.Reader) (string, error) {
+	var n [1]byte
+	if _, err := io.ReadFull(r, n[:]); err != nil {
+		return "", err
+	}
+	b := make([]byte, n[0])
+	if _, err := io.ReadFull(r, b); err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func (s *Server) connect(ctx context.Context, conn net.Conn, address string) error {
+	target, err := s.Dialer(ctx, "tcp", address)
+	if errors.Is(err, dialer.ErrDropped) {
+		return err
+	}
+	if err != nil {
+		writeReply(conn, ReplyFor(err), nil)
+		return err
+	}
+	defer target.Close()
+
+	if err := writeReply(conn, Succeeded, target.LocalAddr()); err != nil {
+		return err
+	}
+	netutil.Join(conn, target)
+	return nil
+}
+
+// associate relays UDP datagrams for the client until the control
+// connection is closed.
+func (s *Server) associate(ctx context.Context, conn net.Conn, r io.Reader) error {
+	host, _, err := net.SplitHostPort(conn.LocalAddr().String())
+	if err != nil {
+		return err
+	}
+	pc, err := net.ListenPacket("udp", net.JoinHostPort(host, "0"))
+	if err != nil {
+		writeReply(conn, GeneralFailure, nil)
+		return err
+	}
+	defer pc.Close()
+
+	if err := writeReply(conn, Succeeded, pc.LocalAddr()); err != nil {
+		return err
+	}
+
+	// the association ends when the control connection does
+	go func() {
+		io.Copy(io.Discard, r)
+		pc.Close()
+	}()
+
+	clientIP, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+	relay := udpRelay{server: s, pc: pc, targets: make(map[string]net.Conn)}
+	defer relay.close()
+
+	buf := make([]byte, 64*1024)
+	for {
+		n, from, err := pc.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		if ip, _, _ := net.SplitHostPort(from.String()); ip != clientIP {
+			continue
+		}
+		relay.forward(ctx, from, buf[:n])
+	}
+}
+
+type udpRelay struct {
+	server  *Server
+	pc      net.PacketConn
+	targets map[string]net.Conn // only used by the reading goroutine
+}
+
+// forward sends a datagram from the client to its target.
+func (u *udpRelay) forward(ctx context.Context, client net.Addr, pkt []byte) {
+	// RSV(2) FRAG(1), fragmented datagrams are not supported
+	if len(pkt) < 4 || pkt[2] != 0 {
+		return
+	}
+	r := bytes.NewBuffer(pkt[3:])
+	address, err := readAddr(r)
+	if err != nil {
+		return
+	}
+
+	target, ok := u.targets[address]
+	if !ok {
+		target, err = u.server.Dialer(ctx, "udp", address)
+		if err != nil {
+			slog.Debug("socks udp dial failed", slog.String("address", address), slog.Any("error", err))
+			return
+		}
+		u.targets[address] = target
+		go u.backward(client, address, target)
+	}
+	target.Write(r.Bytes())
+}
+
+// backward sends datagrams from a target back to the client.
+func (u *udpRelay) backward(client net.Addr, address string, target net.Conn) {
+	hdr := appendAddr([]byte{0, 0, 0}, address)
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := target.Read(buf)
+		if err != nil {
+			return
+		}
+		u.pc.WriteTo(append(hdr[:len(hdr):len(hdr)], buf[:n]...), client)
+	}
+}
+
+func (u *udpRelay) close() {
+	for _, c := range u.targets {
+		c.Close()
+	}
+}
+
+// readAddr reads ATYP, DST.ADDR and DST.PORT and returns them as host:port.
+func readAddr(r io.Reader) (string, error) {
+	var atyp [1]byte
+	if _, err := io.ReadFull(r, atyp[:]); err != nil {
+		return "", err
+	}
+	var host string
+	switch atyp[0] {
+	case atypIPv4, atypIPv6:
+		ip := make([]byte, 4)
+		if atyp[0] == atypIPv6 {
+			ip = make([]byte, 16)
+		}
+		if _, err := io.ReadFull(r, ip); err != nil {
+			return "", err
+		}
+		host = net.IP(ip).String()
+	case atypDomain:
+		var n [1]byte
+		if _, err := io.ReadFull(r, n[:]); err != nil {
+			return "", err
+		}
+		name := make([]byte, n[0])
+		if _, err := io.ReadFull(r, name); err != nil {
+			return "", err
+		}
+		host = string(name)
+	default:
+		return "", fmt.Errorf("unsupported address type %d", atyp[0])
+	}
+	var port [2]byte
+	if _, err := io.ReadFull(r, port[:]); err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port[:])))), nil
+}
+
+// appendAddr appends address in the ATYP, ADDR, PORT wire format.
+func appendAddr(b []byte, address string) []byte {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return append(b, atypIPv4, 0, 0, 0, 0, 0, 0)
+	}
+	p, _ := strconv.ParseUint(port, 10, 16)
+	if ip, err := netip.ParseAddr(host); err == nil {
+		ip = ip.Unmap()
+		if ip.Is4() {
+			b = append(b, atypIPv4)
+		} else {
+			b = append(b, atypIPv6)
+		}
+		b = append(b, ip.AsSlice()...)
+	} else {
+		b = append(b, atypDomain, byte(len(host)))
+		b = append(b, host...)
+	}
+	return binary.BigEndian.AppendUint16(b, uint16(p))
+}
+
+// writeReply writes a reply with the bound address, if any.
+func writeReply(w io.Writer, rep Reply, bound net.Addr) error {
+	address := "0.0.0.0:0"
+	if bound != nil {
+		address = bound.String()
+	}
+	_, err := w.Write(appendAddr([]byte{socks5Version, byte(rep), 0}, address))
+	return err
+}
@@ -0,0 +1,278 @@
+package socksproxy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/gilliginsisland/pacman/pkg/dialer"
+)
+
+// listen serves the server on a loopback listener and returns its address.
+func listen(t *testing.T, serve func(net.Listener) error) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go serve(l)
+	return l.Addr().String()
+}
+
+// echoTCP serves a TCP echo server and returns its address.
+func echoTCP(t *testing.T) string {
+	t.Helper()
+	return listen(t, func(l net.Listener) error {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return err
+			}
+			go func() {
+				defer c.Close()
+				io.Copy(c, c)
+			}()
+		}
+	})
+}
+
+// echoUDP serves a UDP echo server and returns its address.
+func echoUDP(t *testing.T) string {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	go func() {
+		buf := make([]byte, 64*1024)
+		for {
+			n, from, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			pc.WriteTo(buf[:n], from)
+		}
+	}()
+	return pc.LocalAddr().String()
+}
+
+// dialSOCKS5 connects to the server, offers the methods and
+// reads the method the server selects.
+func dialSOCKS5(t *testing.T, addr string, methods ...byte) (net.Conn, *bufio.Reader, byte) {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	conn.Write(append([]byte{socks5Version, byte(len(methods))}, methods...))
+	br := bufio.NewReader(conn)
+	var sel [2]byte
+	if _, err := io.ReadFull(br, sel[:]); err != nil {
+		t.Fatal(err)
+	}
+	if sel[0] != socks5Version {
+		t.Fatalf("got version %d, want %d", sel[0], socks5Version)
+	}
+	return conn, br, sel[1]
+}
+
+// request sends the command for address and reads the reply.
+func request(t *testing.T, conn net.Conn, br *bufio.Reader, cmd byte, address string) (Reply, string) {
+	t.Helper()
+	conn.Write(appendAddr([]byte{socks5Version, cmd, 0}, address))
+	var hdr [3]byte
+	if _, err := io.ReadFull(br, hdr[:]); err != nil {
+		t.Fatal(err)
+	}
+	bound, err := readAddr(br)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Reply(hdr[1]), bound
+}
+
+func TestServerConnect(t *testing.T) {
+	echo := echoTCP(t)
+	addr := listen(t, (&Server{
+		Dialer: func(ctx context.Context, network, address string) (net.Conn, error) {
+			switch address {
+			case "rejected.test:80":
+				return nil, dialer.ErrRejected
+			case "dropped.test:80":
+				return nil, dialer.ErrDropped
+			case "refused.test:80":
+				return nil, &net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}
+			}
+			return new(net.Dialer).DialContext(ctx, network, echo)
+		},
+	}).Serve)
+
+	t.Run("no acceptable method", func(t *testing.T) {
+		_, _, method := dialSOCKS5(t, addr, userPassAuth)
+		if method != noAcceptableAuth {
+			t.Errorf("got method %d, want %d", method, noAcceptableAuth)
+		}
+	})
+
+	t.Run("succeeded", func(t *testing.T) {
+		conn, br, method := dialSOCKS5(t, addr, userPassAuth, noAuthRequired)
+		if method != noAuthRequired {
+			t.Fatalf("got method %d, want %d", method, noAuthRequired)
+		}
+		rep, _ := request(t, conn, br, cmdConnect, "echo.test:80")
+		if rep != Succeeded {
+			t.Fatalf("got reply %d, want %d", rep, Succeeded)
+		}
+		conn.Write([]byte("ping"))
+		got := make([]byte, 4)
+		if _, err := io.ReadFull(br, got); err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != "ping" {
+			t.Errorf("got %q, want %q", got, "ping")
+		}
+	})
+
+	replies := []struct {
+		address string
+		expect  Reply
+	}{
+		{"rejected.test:80", NotAllowedByRuleset},
+		{"refused.test:80", ConnectionRefused},
+	}
+	for _, tc := range replies {
+		t.Run(tc.address, func(t *testing.T) {
+			conn, br, _ := dialSOCKS5(t, addr, noAuthRequired)
+			if rep, _ := request(t, conn, br, cmdConnect, tc.address); rep != tc.expect {
+				t.Errorf("got reply %d, want %d", rep, tc.expect)
+			}
+		})
+	}
+
+	t.Run("dropped", func(t *testing.T) {
+		conn, br, _ := dialSOCKS5(t, addr, noAuthRequired)
+		conn.Write(appendAddr([]byte{socks5Version, cmdConnect, 0}, "dropped.test:80"))
+		if b, err := br.ReadByte(); err != io.EOF {
+			t.Errorf("got %d, %v, want the connection closed without a reply", b, err)
+		}
+	})
+
+	t.Run("unsupported command", func(t *testing.T) {
+		conn, br, _ := dialSOCKS5(t, addr, noAuthRequired)
+		if rep, _ := request(t, conn, br, 2, "echo.test:80"); rep != CommandNotSupported {
+			t.Errorf("got reply %d, want %d", rep, CommandNotSupported)
+		}
+	})
+}
+
+func TestServerUDPAssociate(t *testing.T) {
+	echo := echoUDP(t)
+	addr := listen(t, (&Server{
+		Dialer: func(ctx context.Context, network, address string) (net.Conn, error) {
+			if network != "udp" || address != "echo.test:53" {
+				return nil, dialer.ErrRejected
+			}
+			return new(net.Dialer).DialContext(ctx, network, echo)
+		},
+	}).Serve)
+
+	conn, br, _ := dialSOCKS5(t, addr, noAuthRequired)
+	rep, bound := request(t, conn, br, cmdUDPAssociate, "0.0.0.0:0")
+	if rep != Succeeded {
+		t.Fatalf("got reply %d, want %d", rep, Succeeded)
+	}
+
+	pc, err := net.Dial("udp", bound)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	pc.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// a fragment and a datagram for a rejected target are dropped
+	pc.Write(append(appendAddr([]byte{0, 0, 1}, "echo.test:53"), "fragment"...))
+	pc.Write(append(appendAddr([]byte{0, 0, 0}, "other.test:53"), "rejected"...))
+	pc.Write(append(appendAddr([]byte{0, 0, 0}, "echo.test:53"), "ping"...))
+
+	buf := make([]byte, 1024)
+	n, err := pc.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := bytes.NewBuffer(buf[3:n])
+	from, err := readAddr(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if from != "echo.test:53" || r.String() != "ping" {
+		t.Errorf("got %q from %s, want %q from echo.test:53", r.String(), from, "ping")
+	}
+}
+
+func TestServerUserPassAuth(t *testing.T) {
+	echo := echoTCP(t)
+	addr := listen(t, (&Server{
+		Dialer: func(ctx context.Context, network, address string) (net.Conn, error) {
+			return new(net.Dialer).DialContext(ctx, network, echo)
+		},
+		Authenticate: func(username, password string) bool {
+			return username == "alice" && password == "secret"
+		},
+	}).Serve)
+
+	t.Run("no auth offered", func(t *testing.T) {
+		_, _, method := dialSOCKS5(t, addr, noAuthRequired)
+		if method != noAcceptableAuth {
+			t.Errorf("got method %d, want %d", method, noAcceptableAuth)
+		}
+	})
+
+	tests := []struct {
+		username, password string
+		status             byte
+	}{
+		{"alice", "secret", userPassSuccess},
+		{"alice", "wrong", userPassFailure},
+		{"", "", userPassFailure},
+	}
+	for _, tc := range tests {
+		t.Run(tc.username+":"+tc.password, func(t *testing.T) {
+			conn, br, method := dialSOCKS5(t, addr, noAuthRequired, userPassAuth)
+			if method != userPassAuth {
+				t.Fatalf("got method %d, want %d", method, userPassAuth)
+			}
+			req := []byte{userPassVersion, byte(len(tc.username))}
+			req = append(req, tc.username...)
+			req = append(append(req, byte(len(tc.password))), tc.password...)
+			conn.Write(req)
+			var status [2]byte
+			if _, err := io.ReadFull(br, status[:]); err != nil {
+				t.Fatal(err)
+			}
+			if status != [2]byte{userPassVersion, tc.status} {
+				t.Fatalf("got status %v, want %v", status, [2]byte{userPassVersion, tc.status})
+			}
+
+			if tc.status != userPassSuccess {
+				// the server closes the connection
+				if b, err := br.ReadByte(); err != io.EOF {
+					t.Errorf("got %d, %v, want the connection closed", b, err)
+				}
+				return
+			}
+			if rep, _ := request(t, conn, br, cmdConnect, "echo.test:80"); rep != Succeeded {
+				t.Errorf("got reply %d, want %d", rep, Succeeded)
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+package socksproxy
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/netip"
+	"strconv"
+
+	"github.com/gilliginsisland/pacman/pkg/dialer"
+	"github.com/gilliginsisland/pacman/pkg/netutil"
+)
+
+const socks4Version = 4
+
+// SOCKS4 reply codes, sent with version 0
+const (
+	socks4Granted  byte = 90
+	socks4Rejected byte = 91
+)
+
+// maxSOCKS4Field is the longest userid or host name read from a request.
+const maxSOCKS4Field = 255
+
+// SOCKS4Server is a SOCKS4 and SOCKS4a proxy server supporting the
+// CONNECT command. SOCKS4a clients send a host name, resolved by the
+// dialer, in place of an IP address.
+type SOCKS4Server struct {
+	Dialer func(ctx context.Context, network, address string) (net.Conn, error)
+	// Authenticate, if set, reports whether clients sending the userid
+	// may connect. SOCKS4 has no passwords, the userid is only a name.
+	Authenticate func(userid string) bool
+}
+
+// Serve accepts connections on the listener and serves them.
+func (s *SOCKS4Server) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go s.handleConn(conn)
+	}
+}
+
+func (s *SOCKS4Server) handleConn(conn net.Conn) {
+	defer conn.Close()
+
+	br := bufio.NewReader(conn)
+	cmd, userid, address, err := readSOCKS4Request(br)
+	if err != nil {
+		slog.Debug("socks4 handshake failed", slog.Any("error", err))
+		return
+	}
+	if s.Authenticate != nil && !s.Authenticate(userid) {
+		writeSOCKS4Reply(conn, socks4Rejected, nil)
+		slog.Warn("socks client rejected",
+			slog.String("client", conn.RemoteAddr().String()),
+			slog.String("userid", userid),
+			slog.Any("error", ErrAuthFailed),
+		)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(netutil.WithClient(context.Background(), conn.RemoteAddr().String()))
+	defer cancel()
+
+	switch cmd {
+	case cmdConnect:
+		err = s.connect(ctx, &netutil.BuffConn{
+			Conn:       conn,
+			ReadWriter: bufio.NewReadWriter(br, bufio.NewWriter(conn)),
+		}, address)
+	default:
+		writeSOCKS4Reply(conn, socks4Rejected, nil)
+		err = fmt.Errorf("unsupported command %d", cmd)
+	}
+	if err != nil {
+		slog.Error("socks4 request failed",
+			slog.String("address", address),
+			slog.String("userid", userid),
+			slog.Any("error", err),
+		)
+	}
+}
+
+// readSOCKS4Request reads VN, CD, DSTPORT, DSTIP and USERID, followed
+// by the host name of SOCKS4a requests, whose DSTIP is 0.0.0.x with
+// x not zero. It returns the command, the userid and host:port.
+func readSOCKS4Request(r *bufio.Reader) (byte, string, string, error) {
+	var req [8]byte
+	if _, err := io.ReadFull(r, req[:]); err != nil {
+		return 0, "", "", err
+	}
+	if req[0] != socks4Version {
+		return 0, "", "", fmt.Errorf("unsupported socks version %d", req[0])
+	}
+	port := binary.BigEndian.Uint16(req[2:4])
+	ip := netip.AddrFrom4([4]byte(req[4:8]))
+	userid, err := readCString(r)
+	if err != nil {
+		return 0, "", "", fmt.Errorf("failed to read userid: %w", err)
+	}
+
+	host := ip.String()
+	if ip4 := ip.As4(); ip4[0] == 0 && ip4[1] == 0 && ip4[2] == 0 && ip4[3] != 0 {
+		if host, err = readCString(r); err != nil {
+			return 0, "", "", fmt.Errorf("failed to read host name: %w", err)
+		}
+		if host == "" {
+			return 0, "", "", errors.New("empty host name")
+		}
+	}
+	return req[1], userid, net.JoinHostPort(host, strconv.Itoa(int(port))), nil
+}
+
+// readCString reads a string terminated by a null byte.
+func readCString(r *bufio.Reader) (string, error) {
+	var b []byte
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		if c == 0 {
+			return string(b), nil
+		}
+		if len(b) == maxSOCKS4Field {
+			return "", fmt.Errorf("longer than %d bytes", maxSOCKS4Field)
+		}
+		b = append(b, c)
+	}
+}
+
+func (s *SOCKS4Server) connect(ctx context.Context, conn net.Conn, address string) error {
+	target, err := s.Dialer(ctx, "tcp", address)
+	if errors.Is(err, dialer.ErrDropped) {
+		return err
+	}
+	if err != nil {
+		writeSOCKS4Reply(conn, socks4Rejected, nil)
+		return err
+	}
+	defer target.Close()
+
+	if err := writeSOCKS4Reply(conn, socks4Granted, target.LocalAddr()); err != nil {
+		return err
+	}
+	netutil.Join(conn, target)
+	return nil
+}
+
+// writeSOCKS4Reply writes a reply with the bound address, if it is
+// an IPv4 address. Other addresses are sent as zeros.
+func writeSOCKS4Reply(w io.Writer, cd byte, bound net.Addr) error {
+	reply := []byte{0, cd, 0, 0, 0, 0, 0, 0}
+	if bound != nil {
+		if ap, err := netip.ParseAddrPort(bound.String()); err == nil && ap.Addr().Unmap().Is4() {
+			binary.BigEndian.PutUint16(reply[2:4], ap.Port())
+			ip4 := ap.Addr().Unmap().As4()
+			copy(reply[4:], ip4[:])
+		}
+	}
+	_, err := w.Write(reply)
+	return err
+}
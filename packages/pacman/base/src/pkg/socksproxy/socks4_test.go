@@ -0,0 +1,142 @@
+package socksproxy
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gilliginsisland/pacman/pkg/dialer"
+)
+
+// socks4Request builds a request, a SOCKS4a one if host is set.
+func socks4Request(cmd byte, ip [4]byte, port uint16, userid, host string) []byte {
+	b := []byte{socks4Version, cmd}
+	b = binary.BigEndian.AppendUint16(b, port)
+	b = append(b, ip[:]...)
+	b = append(append(b, userid...), 0)
+	if host != "" {
+		b = append(append(b, host...), 0)
+	}
+	return b
+}
+
+func TestSOCKS4Server(t *testing.T) {
+	echo := echoTCP(t)
+	var (
+		mu     sync.Mutex
+		dialed []string
+	)
+	addr := listen(t, (&SOCKS4Server{
+		Dialer: func(ctx context.Context, network, address string) (net.Conn, error) {
+			mu.Lock()
+			dialed = append(dialed, address)
+			mu.Unlock()
+			switch address {
+			case "rejected.test:80":
+				return nil, dialer.ErrRejected
+			case "dropped.test:80":
+				return nil, dialer.ErrDropped
+			}
+			return new(net.Dialer).DialContext(ctx, network, echo)
+		},
+		Authenticate: func(userid string) bool {
+			return userid == "alice"
+		},
+	}).Serve)
+
+	tests := []struct {
+		name    string
+		request []byte
+		address string // dialed
+		expect  int    // reply code, or -1 if the connection is closed
+	}{
+		{
+			name:    "socks4",
+			request: socks4Request(cmdConnect, [4]byte{192, 0, 2, 1}, 80, "alice", ""),
+			address: "192.0.2.1:80",
+			expect:  int(socks4Granted),
+		},
+		{
+			name:    "socks4a",
+			request: socks4Request(cmdConnect, [4]byte{0, 0, 0, 1}, 443, "alice", "echo.test"),
+			address: "echo.test:443",
+			expect:  int(socks4Granted),
+		},
+		{
+			name:    "unknown userid",
+			request: socks4Request(cmdConnect, [4]byte{192, 0, 2, 1}, 80, "mallory", ""),
+			expect:  int(socks4Rejected),
+		},
+		{
+			name:    "bind",
+			request: socks4Request(2, [4]byte{192, 0, 2, 1}, 80, "alice", ""),
+			expect:  int(socks4Rejected),
+		},
+		{
+			name:    "rejected",
+			request: socks4Request(cmdConnect, [4]byte{0, 0, 0, 1}, 80, "alice", "rejected.test"),
+			address: "rejected.test:80",
+			expect:  int(socks4Rejected),
+		},
+		{
+			name:    "dropped",
+			request: socks4Request(cmdConnect, [4]byte{0, 0, 0, 1}, 80, "alice", "dropped.test"),
+			address: "dropped.test:80",
+			expect:  -1,
+		},
+		{
+			name:    "empty host name",
+			request: socks4Request(cmdConnect, [4]byte{0, 0, 0, 1}, 80, "alice", "\x00"),
+			expect:  -1,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			mu.Lock()
+			dialed = nil
+			mu.Unlock()
+			conn, err := net.Dial("tcp", addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+			conn.Write(tc.request)
+
+			var reply [8]byte
+			_, err = io.ReadFull(conn, reply[:])
+			switch {
+			case tc.expect < 0:
+				if err != io.EOF {
+					t.Fatalf("got reply %v, %v, want the connection closed", reply, err)
+				}
+			case err != nil:
+				t.Fatal(err)
+			case reply[0] != 0 || int(reply[1]) != tc.expect:
+				t.Fatalf("got reply %v, want code %d", reply, tc.expect)
+			}
+			mu.Lock()
+			if tc.address != "" && (len(dialed) != 1 || dialed[0] != tc.address) {
+				t.Errorf("got dialed %q, want %q", dialed, tc.address)
+			}
+			mu.Unlock()
+			if tc.expect != int(socks4Granted) {
+				return
+			}
+
+			conn.Write([]byte("ping"))
+			got := make([]byte, 4)
+			if _, err := io.ReadFull(conn, got); err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != "ping" {
+				t.Errorf("got %q, want %q", got, "ping")
+			}
+		})
+	}
+}
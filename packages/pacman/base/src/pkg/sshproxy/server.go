@@ -6,10 +6,14 @@ import (
 	"crypto/rand"
 	"crypto/rsa"
 	"encoding/pem"
+	"errors"
 	"fmt"
+	"log/slog"
 	"net"
 	"os"
+	"strconv"
 
+	"github.com/gilliginsisland/pacman/pkg/dialer"
 	"github.com/gilliginsisland/pacman/pkg/netutil"
 	"golang.org/x/crypto/ssh"
 )
@@ -26,8 +30,14 @@ type Server struct {
 	config  *ssh.ServerConfig
 	Dialer  func(ctx context.Context, network, address string) (net.Conn, error)
 	HostKey ssh.Signer
+	// PublicKey, if set, requires clients to authenticate with a
+	// public key, and reports whether the key is authorized.
+	PublicKey func(key ssh.PublicKey) bool
 }
 
+// ErrUnauthorized is returned for public keys that are not authorized.
+var ErrUnauthorized = errors.New("public key not authorized")
+
 // loadOrGenerateHostKey loads an existing host key or generates a new one, storing it in the user's home directory.
 func LoadOrGenerateHostKey(keyPath string) (ssh.Signer, error) {
 	// Check if host key exists
@@ -77,7 +87,15 @@ func (s *Server) Serve(l net.Listener) error {
 	// Initialize the SSH server configuration if not already done
 	if s.config == nil {
 		s.config = &ssh.ServerConfig{
-			NoClientAuth: true, // No authentication required
+			NoClientAuth: s.PublicKey == nil, // No authentication required
+		}
+		if s.PublicKey != nil {
+			s.config.PublicKeyCallback = func(meta ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+				if !s.PublicKey(key) {
+					return nil, ErrUnauthorized
+				}
+				return nil, nil
+			}
 		}
 		s.config.AddHostKey(s.HostKey)
 	}
@@ -95,6 +113,10 @@ func (s *Server) Serve(l net.Listener) error {
 func (s *Server) handleConn(conn net.Conn) {
 	sshConn, chans, reqs, err := ssh.NewServerConn(conn, s.config)
 	if err != nil {
+		var authErr *ssh.ServerAuthError
+		if errors.As(err, &authErr) {
+			slog.Warn("ssh client rejected", slog.String("client", conn.RemoteAddr().String()), slog.Any("error", err))
+		}
 		conn.Close()
 		return
 	}
@@ -110,11 +132,11 @@ func (s *Server) handleConn(conn net.Conn) {
 			continue
 		}
 
-		go s.handleDirectTCPIP(newChan)
+		go s.handleDirectTCPIP(sshConn.RemoteAddr(), newChan)
 	}
 }
 
-func (s *Server) handleDirectTCPIP(newChan ssh.NewChannel) {
+func (s *Server) handleDirectTCPIP(client net.Addr, newChan ssh.NewChannel) {
 	// Parse the direct-tcpip request parameters
 	var payload DirectTCPIPPayload
 	if err := ssh.Unmarshal(newChan.ExtraData(), &payload); err != nil {
@@ -122,23 +144,29 @@ func (s *Server) handleDirectTCPIP(newChan ssh.NewChannel) {
 		return
 	}
 
-	ch, reqs, err := newChan.Accept()
-	if err != nil {
-		return
-	}
-	defer ch.Close()
-
 	// Dial the target using the provided Dialer with a cancellable context
-	ctx, cancel := context.WithCancel(context.Background())
+	ctx, cancel := context.WithCancel(netutil.WithClient(context.Background(), client.String()))
 	defer cancel() // Ensure context is cancelled when function returns
 
-	targetAddr := fmt.Sprintf("%s:%d", payload.HostToConnect, payload.PortToConnect)
+	// dial before accepting, so failures can be reported with the rejection
+	targetAddr := net.JoinHostPort(payload.HostToConnect, strconv.Itoa(int(payload.PortToConnect)))
 	conn, err := s.Dialer(ctx, "tcp", targetAddr)
+	if errors.Is(err, dialer.ErrRejected) {
+		newChan.Reject(ssh.Prohibited, dialer.ErrRejected.Error())
+		return
+	}
 	if err != nil {
+		newChan.Reject(ssh.ConnectionFailed, err.Error())
 		return
 	}
 	defer conn.Close()
 
+	ch, reqs, err := newChan.Accept()
+	if err != nil {
+		return
+	}
+	defer ch.Close()
+
 	// Handle channel requests (e.g., EOF)
 	go ssh.DiscardRequests(reqs)
 
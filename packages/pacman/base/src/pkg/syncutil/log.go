@@ -0,0 +1,101 @@
+package syncutil
+
+import (
+	"context"
+	"iter"
+	"sync"
+	"time"
+)
+
+// DefaultLogSize is the number of entries a Log keeps if its Size is zero.
+const DefaultLogSize = 64
+
+// LogEntry is a value appended to a Log.
+type LogEntry[T any] struct {
+	Seq   uint64 // position in the log, starting at 1
+	Time  time.Time
+	Value T
+}
+
+// Log is an ordered log of the most recent values appended to it.
+// Appending never blocks on readers. A reader that falls more than
+// Size entries behind skips the entries it missed, which shows as
+// a gap in the sequence numbers.
+type Log[T any] struct {
+	Size int // entries kept, DefaultLogSize if zero
+
+	mu      sync.Mutex
+	entries []LogEntry[T] // oldest first
+	seq     uint64
+	wake    chan struct{} // closed on the next append
+}
+
+// Append adds a value to the log and returns its entry.
+func (l *Log[T]) Append(v T) LogEntry[T] {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	size := l.Size
+	if size <= 0 {
+		size = DefaultLogSize
+	}
+	l.seq++
+	e := LogEntry[T]{Seq: l.seq, Time: time.Now(), Value: v}
+	if len(l.entries) >= size {
+		l.entries = append(l.entries[:0], l.entries[len(l.entries)-size+1:]...)
+	}
+	l.entries = append(l.entries, e)
+
+	if l.wake != nil {
+		close(l.wake)
+		l.wake = nil
+	}
+	return e
+}
+
+// Last returns the sequence number of the last entry, 0 if there is none.
+func (l *Log[T]) Last() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.seq
+}
+
+// after returns the entries after seq that are still in the log, and a
+// channel that is closed when the next entry is appended.
+func (l *Log[T]) after(seq uint64) ([]LogEntry[T], <-chan struct{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var entries []LogEntry[T]
+	for i, e := range l.entries {
+		if e.Seq > seq {
+			entries = append(entries, l.entries[i:]...)
+			break
+		}
+	}
+	if l.wake == nil {
+		l.wake = make(chan struct{})
+	}
+	return entries, l.wake
+}
+
+// Follow yields the entries after seq, first those still in the log and
+// then new ones as they are appended, until ctx is done.
+func (l *Log[T]) Follow(ctx context.Context, seq uint64) iter.Seq[LogEntry[T]] {
+	return func(yield func(LogEntry[T]) bool) {
+		for {
+			entries, wake := l.after(seq)
+			for _, e := range entries {
+				if !yield(e) {
+					return
+				}
+				seq = e.Seq
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-wake:
+			}
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package syncutil
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// follow collects the values following seq until n are read or ctx is done.
+func follow(ctx context.Context, l *Log[int], seq uint64, n int) (values []int, seqs []uint64) {
+	for e := range l.Follow(ctx, seq) {
+		values, seqs = append(values, e.Value), append(seqs, e.Seq)
+		if len(values) == n {
+			break
+		}
+	}
+	return values, seqs
+}
+
+func TestLogReplay(t *testing.T) {
+	l := Log[int]{Size: 3}
+	if last := l.Last(); last != 0 {
+		t.Fatalf("got last %d of an empty log, want 0", last)
+	}
+	for i := 1; i <= 5; i++ {
+		if e := l.Append(i * 10); e.Seq != uint64(i) {
+			t.Fatalf("got seq %d, want %d", e.Seq, i)
+		}
+	}
+	if last := l.Last(); last != 5 {
+		t.Fatalf("got last %d, want 5", last)
+	}
+
+	tests := []struct {
+		since  uint64
+		expect []int
+		seqs   []uint64
+	}{
+		{0, []int{30, 40, 50}, []uint64{3, 4, 5}}, // older entries are dropped
+		{3, []int{40, 50}, []uint64{4, 5}},
+		{5, nil, nil},
+	}
+	for _, tc := range tests {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		values, seqs := follow(ctx, &l, tc.since, len(tc.expect))
+		cancel()
+		if !slices.Equal(values, tc.expect) || !slices.Equal(seqs, tc.seqs) {
+			t.Errorf("since %d: got %v %v, want %v %v", tc.since, values, seqs, tc.expect, tc.seqs)
+		}
+	}
+}
+
+func TestLogFollow(t *testing.T) {
+	var l Log[int]
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	const n = 50
+	var (
+		wg     sync.WaitGroup
+		values []int
+	)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		values, _ = follow(ctx, &l, 0, n)
+	}()
+	for i := range n {
+		l.Append(i)
+	}
+	wg.Wait()
+
+	want := make([]int, n)
+	for i := range want {
+		want[i] = i
+	}
+	if !slices.Equal(values, want) {
+		t.Errorf("got %v, want %v", values, want)
+	}
+}
+
+func TestLogFollowCancel(t *testing.T) {
+	var l Log[int]
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		follow(ctx, &l, 0, -1)
+	}()
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Follow did not return after the context was cancelled")
+	}
+}
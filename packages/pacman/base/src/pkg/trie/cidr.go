@@ -1,6 +1,7 @@
 package trie
 
 import (
+	"iter"
 	"net"
 	"slices"
 )
@@ -20,6 +21,7 @@ func (t *CIDR[V]) Insert(cidr *net.IPNet, value V) {
 		curr, _ := existing.Network.Mask.Size()
 		if ones > curr {
 			*t = slices.Insert(*t, i, &m)
+			return
 		}
 	}
 
@@ -35,3 +37,15 @@ func (t *CIDR[V]) Match(ip net.IP) (V, bool) {
 	var zero V
 	return zero, false
 }
+
+// Matches iterates over the networks containing ip, in the order Match
+// checks them.
+func (t *CIDR[V]) Matches(ip net.IP) iter.Seq[V] {
+	return func(yield func(V) bool) {
+		for _, n := range *t {
+			if n.Network.Contains(ip) && !yield(n.Value) {
+				return
+			}
+		}
+	}
+}
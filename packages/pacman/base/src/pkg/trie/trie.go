@@ -28,6 +28,21 @@ func (m *Trie[V]) Insert(host string, value V) {
 	}
 }
 
+// Keys returns the entries Insert stores a pattern under, written as Walk
+// yields them. A ".example.com" pattern is stored as both the host
+// example.com and the zone *.example.com, and patterns sharing an entry
+// replace each other.
+func Keys(pattern string) []string {
+	if _, ipnet, err := net.ParseCIDR(pattern); err == nil {
+		return []string{ipnet.String()}
+	}
+	pattern = canonocalizeHost(pattern)
+	if base, ok := strings.CutPrefix(pattern, "."); ok {
+		return []string{base, "*." + base}
+	}
+	return []string{pattern}
+}
+
 func (m *Trie[V]) Match(host string) (V, bool) {
 	if v, ok := m.host.Match(host); ok {
 		return v, ok
@@ -39,6 +54,68 @@ func (m *Trie[V]) Match(host string) (V, bool) {
 	return m.zone.Match(host)
 }
 
+// Matches iterates over the values matching host, from the most specific
+// match to the least specific one.
+func (m *Trie[V]) Matches(host string) iter.Seq[V] {
+	return func(yield func(V) bool) {
+		for _, v := range m.MatchKinds(host) {
+			if !yield(v) {
+				return
+			}
+		}
+	}
+}
+
+// Kind is how a host matched a pattern.
+type Kind int
+
+const (
+	KindExact Kind = iota // the host name or IP address itself
+	KindZone              // a subdomain of a zone
+	KindCIDR              // an IP address in a CIDR range
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindExact:
+		return "exact"
+	case KindZone:
+		return "zone"
+	case KindCIDR:
+		return "cidr"
+	}
+	return "unknown"
+}
+
+// MatchKinds is like Matches, and also yields how each value matched.
+func (m *Trie[V]) MatchKinds(host string) iter.Seq2[Kind, V] {
+	return func(yield func(Kind, V) bool) {
+		if v, ok := m.host.Match(host); ok {
+			if !yield(KindExact, v) {
+				return
+			}
+		}
+		if ip := net.ParseIP(host); ip != nil {
+			for v := range m.cidr.Matches(ip) {
+				if !yield(KindCIDR, v) {
+					return
+				}
+			}
+			return
+		}
+		for v := range m.zone.Matches(host) {
+			if !yield(KindZone, v) {
+				return
+			}
+		}
+	}
+}
+
+// Canonical returns host the way patterns are matched against it.
+func Canonical(host string) string {
+	return canonocalizeHost(host)
+}
+
 var _ iter.Seq2[string, struct{}] = (*Trie[struct{}])(nil).Walk
 
 func (m *Trie[V]) Walk(yield func(string, V) bool) {
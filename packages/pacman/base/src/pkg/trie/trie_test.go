@@ -1,6 +1,9 @@
 package trie
 
-import "testing"
+import (
+	"slices"
+	"testing"
+)
 
 func TestHostTrieMatch(t *testing.T) {
 	var tree Trie[string]
@@ -31,6 +34,7 @@ func TestHostTrieMatch(t *testing.T) {
 		{"wrong.domain.net", "", false},
 		{"x.b.com", "", false},                        // early miss
 		{"x.a.b.com", "wild-a-b", true},               // deep match
+		{"a.b.com", "", false},                        // a zone does not cover itself
 		{"foo.co.uk", "wild-co-uk", true},             // multi-part tld
 		{"FOO.EXAMPLE.ORG", "wild-example-org", true}, // case insensitivity
 	}
@@ -48,3 +52,69 @@ func TestHostTrieMatch(t *testing.T) {
 		})
 	}
 }
+
+func TestHostTrieMatches(t *testing.T) {
+	var tree Trie[string]
+
+	tree.Insert("*.example.com", "wild-example")
+	tree.Insert("*.test.example.com", "wild-test-example")
+	tree.Insert("api.test.example.com", "literal-api-test")
+	tree.Insert("10.0.0.0/8", "cidr-8")
+	tree.Insert("10.1.0.0/16", "cidr-16")
+
+	tests := []struct {
+		host   string
+		expect []string
+	}{
+		{"api.test.example.com", []string{"literal-api-test", "wild-test-example", "wild-example"}},
+		{"foo.test.example.com", []string{"wild-test-example", "wild-example"}},
+		{"example.com", nil},
+		{"10.1.2.3", []string{"cidr-16", "cidr-8"}},
+		{"10.2.3.4", []string{"cidr-8"}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.host, func(t *testing.T) {
+			t.Parallel()
+			var got []string
+			for v := range tree.Matches(tc.host) {
+				got = append(got, v)
+			}
+			if !slices.Equal(got, tc.expect) {
+				t.Errorf("got %q, want %q", got, tc.expect)
+			}
+		})
+	}
+}
+
+func TestHostTrieMatchKinds(t *testing.T) {
+	var tree Trie[string]
+
+	tree.Insert(".example.com", "zone-example")
+	tree.Insert("10.0.0.1", "ip")
+	tree.Insert("10.0.0.0/8", "cidr-8")
+
+	tests := []struct {
+		host   string
+		expect []string
+	}{
+		{"example.com", []string{"exact zone-example"}},
+		{"www.Example.com", []string{"zone zone-example"}},
+		{"10.0.0.1", []string{"exact ip", "cidr cidr-8"}},
+		{"10.2.3.4", []string{"cidr cidr-8"}},
+		{"example.org", nil},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.host, func(t *testing.T) {
+			t.Parallel()
+			var got []string
+			for kind, v := range tree.MatchKinds(tc.host) {
+				got = append(got, kind.String()+" "+v)
+			}
+			if !slices.Equal(got, tc.expect) {
+				t.Errorf("got %q, want %q", got, tc.expect)
+			}
+		})
+	}
+}
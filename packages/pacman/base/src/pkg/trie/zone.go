@@ -1,6 +1,7 @@
 package trie
 
 import (
+	"iter"
 	"strings"
 )
 
@@ -60,6 +61,11 @@ func (w *Zone[V]) Match(host string) (V, bool) {
 			break
 		}
 
+		if n == nil && i == 0 {
+			// the host is the zone itself, which only covers its subdomains
+			break
+		}
+
 		if n == nil {
 			// a nil entry means this is the most specific match
 			suffix := strings.Join(labels[i:], ".")
@@ -86,6 +92,25 @@ func (w *Zone[V]) Match(host string) (V, bool) {
 	return zero, false
 }
 
+// Matches iterates over the zones containing host, most specific first.
+func (w *Zone[V]) Matches(host string) iter.Seq[V] {
+	return func(yield func(V) bool) {
+		suffix := canonocalizeHost(host)
+		for {
+			i := strings.IndexByte(suffix, '.')
+			if i < 0 {
+				return
+			}
+			suffix = suffix[i+1:]
+			if v, ok := w.values[suffix]; ok {
+				if !yield(v) {
+					return
+				}
+			}
+		}
+	}
+}
+
 func splitHost(host string) []string {
 	return strings.Split(host, ".")
 }
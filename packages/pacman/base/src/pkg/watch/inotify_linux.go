@@ -0,0 +1,129 @@
+package watch
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const inotifyMask = unix.IN_CLOSE_WRITE | unix.IN_CREATE | unix.IN_DELETE |
+	unix.IN_MOVED_TO | unix.IN_MOVED_FROM | unix.IN_ATTRIB | unix.IN_DELETE_SELF
+
+// inotify watches the parent directories of the watched paths, so that
+// files replaced by a rename (as most editors do) keep being watched.
+type inotify struct {
+	fd      int
+	f       *os.File
+	changed func()
+
+	mu    sync.Mutex
+	wds   map[int32]string    // watch descriptor → directory
+	dirs  map[string]int32    // directory → watch descriptor
+	files map[string]struct{} // watched files
+	trees map[string]struct{} // watched directories
+}
+
+func newBackend(changed func()) (backend, error) {
+	fd, err := unix.InotifyInit1(unix.IN_NONBLOCK | unix.IN_CLOEXEC)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+	in := inotify{
+		fd:      fd,
+		f:       os.NewFile(uintptr(fd), "inotify"),
+		changed: changed,
+		wds:     make(map[int32]string),
+		dirs:    make(map[string]int32),
+	}
+	go in.read()
+	return &in, nil
+}
+
+func (in *inotify) set(paths []string) error {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	files := make(map[string]struct{})
+	trees := make(map[string]struct{})
+	dirs := make(map[string]struct{})
+	for _, p := range paths {
+		if fi, err := os.Stat(p); err == nil && fi.IsDir() {
+			trees[p] = struct{}{}
+			dirs[p] = struct{}{}
+		} else {
+			files[p] = struct{}{}
+		}
+		dirs[filepath.Dir(p)] = struct{}{}
+	}
+
+	var errs []error
+	for dir := range dirs {
+		if _, ok := in.dirs[dir]; ok {
+			continue
+		}
+		wd, err := unix.InotifyAddWatch(in.fd, dir, inotifyMask)
+		if err != nil {
+			errs = append(errs, &os.PathError{Op: "inotify_add_watch", Path: dir, Err: err})
+			continue
+		}
+		in.dirs[dir] = int32(wd)
+		in.wds[int32(wd)] = dir
+	}
+	for dir, wd := range in.dirs {
+		if _, ok := dirs[dir]; ok {
+			continue
+		}
+		unix.InotifyRmWatch(in.fd, uint32(wd))
+		delete(in.dirs, dir)
+		delete(in.wds, wd)
+	}
+
+	in.files, in.trees = files, trees
+	return errors.Join(errs...)
+}
+
+func (in *inotify) close() error {
+	return in.f.Close()
+}
+
+func (in *inotify) read() {
+	var buf [64 * (unix.SizeofInotifyEvent + unix.NAME_MAX + 1)]byte
+	for {
+		n, err := in.f.Read(buf[:])
+		if err != nil {
+			return
+		}
+		for off := 0; off+unix.SizeofInotifyEvent <= n; {
+			ev := (*unix.InotifyEvent)(unsafe.Pointer(&buf[off]))
+			name := buf[off+unix.SizeofInotifyEvent : off+unix.SizeofInotifyEvent+int(ev.Len)]
+			off += unix.SizeofInotifyEvent + int(ev.Len)
+
+			if ev.Mask&unix.IN_Q_OVERFLOW != 0 || in.match(ev.Wd, string(bytes.TrimRight(name, "\x00"))) {
+				in.changed()
+			}
+		}
+	}
+}
+
+func (in *inotify) match(wd int32, name string) bool {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	dir, ok := in.wds[wd]
+	if !ok {
+		return false
+	}
+	if _, ok := in.trees[dir]; ok {
+		return true
+	}
+	_, ok = in.files[filepath.Join(dir, name)]
+	if !ok {
+		_, ok = in.trees[filepath.Join(dir, name)]
+	}
+	return ok
+}
@@ -0,0 +1,95 @@
+//go:build !linux
+
+package watch
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const pollInterval = time.Second
+
+// poll compares the modification time and size of the watched paths
+// at a fixed interval.
+type poll struct {
+	changed func()
+	done    chan struct{}
+
+	mu    sync.Mutex
+	state map[string]string
+}
+
+func newBackend(changed func()) (backend, error) {
+	p := poll{
+		changed: changed,
+		done:    make(chan struct{}),
+	}
+	go p.run()
+	return &p, nil
+}
+
+func (p *poll) set(paths []string) error {
+	state := make(map[string]string, len(paths))
+	for _, path := range paths {
+		state[path] = stat(path)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.state = state
+	return nil
+}
+
+func (p *poll) close() error {
+	close(p.done)
+	return nil
+}
+
+func (p *poll) run() {
+	t := time.NewTicker(pollInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-t.C:
+		}
+		if p.poll() {
+			p.changed()
+		}
+	}
+}
+
+func (p *poll) poll() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var changed bool
+	for path, prev := range p.state {
+		if curr := stat(path); curr != prev {
+			p.state[path] = curr
+			changed = true
+		}
+	}
+	return changed
+}
+
+// stat returns a fingerprint of path, including the entries of a directory.
+func stat(path string) string {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return ""
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%d:%d", fi.ModTime().UnixNano(), fi.Size())
+	if fi.IsDir() {
+		entries, _ := os.ReadDir(path)
+		for _, e := range entries {
+			if info, err := e.Info(); err == nil {
+				fmt.Fprintf(&sb, "|%s:%d:%d", e.Name(), info.ModTime().UnixNano(), info.Size())
+			}
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,81 @@
+// Package watch reports changes to a set of files.
+package watch
+
+import (
+	"math"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+type backend interface {
+	set(paths []string) error
+	close() error
+}
+
+// Watcher debounces change events of the watched paths.
+// A watched directory reports changes to any of its entries.
+type Watcher struct {
+	// C receives a value once the watched paths stopped changing for the
+	// debounce delay.
+	C <-chan struct{}
+
+	c       chan struct{}
+	delay   time.Duration
+	timer   *time.Timer
+	mu      sync.Mutex
+	backend backend
+}
+
+// New returns a Watcher that waits for delay after the last change before
+// signalling C.
+func New(delay time.Duration) (*Watcher, error) {
+	c := make(chan struct{}, 1)
+	w := Watcher{
+		C:     c,
+		c:     c,
+		delay: delay,
+	}
+	w.timer = time.AfterFunc(math.MaxInt64, w.fire)
+	w.timer.Stop()
+
+	b, err := newBackend(w.changed)
+	if err != nil {
+		return nil, err
+	}
+	w.backend = b
+	return &w, nil
+}
+
+// Set replaces the watched paths.
+func (w *Watcher) Set(paths ...string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	abs := make([]string, 0, len(paths))
+	for _, p := range paths {
+		p, err := filepath.Abs(p)
+		if err != nil {
+			return err
+		}
+		abs = append(abs, p)
+	}
+	return w.backend.set(abs)
+}
+
+// Close stops watching. C is not closed.
+func (w *Watcher) Close() error {
+	w.timer.Stop()
+	return w.backend.close()
+}
+
+func (w *Watcher) changed() {
+	w.timer.Reset(w.delay)
+}
+
+func (w *Watcher) fire() {
+	select {
+	case w.c <- struct{}{}:
+	default:
+	}
+}